@@ -0,0 +1,218 @@
+package testworld
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// capturePath is where capture helpers write their pcap file.
+const capturePath = "/tmp/capture.pcap"
+
+// capture is a tcpdump helper recording traffic for the life of the world.
+// The pcap file is copied into the world log directory by Destroy.
+type capture struct {
+	label  string // what is captured, shown in the inventory
+	path   string // destination in the world log directory
+	helper testcontainers.Container
+	owner  *pendingContainer // replica being captured, nil for world captures
+	saved  bool              // set once the helper has been stopped and saved
+}
+
+// Capture records the traffic on the world networks into a pcap file next to
+// the world log until the world is destroyed. filter is an optional tcpdump
+// filter expression, e.g. "tcp port 5432". The networks are shared with other
+// worlds in the test binary, so the capture is limited to the world subnets
+// but may include traffic from parallel worlds. Capture is a no-op if the
+// world has no log path.
+func (w *World) Capture(filter string) {
+	if w.worldLog.dir == "" {
+		w.t.Log("Capture requires a world log path, skipping")
+		return
+	}
+
+	event := w.worldLog.newStepEvent(w.worldLog.currentStep(), "", "World: capture %s", filter)
+	defer event.finish()
+
+	subnets, err := w.subnets(w.ctx)
+	if err != nil {
+		w.t.Fatalf("Failed to determine world subnets for capture: %v", err)
+	}
+	nets := make([]string, len(subnets))
+	for i, s := range subnets {
+		nets[i] = "net " + s.String()
+	}
+	expr := "(" + strings.Join(nets, " or ") + ")"
+	if filter != "" {
+		expr += " and (" + filter + ")"
+	}
+
+	w.mu.Lock()
+	w.networkCaptures++
+	n := w.networkCaptures
+	w.mu.Unlock()
+
+	// Capture on all host interfaces, but only packets received from a
+	// container, so bridged packets are not recorded twice (once per veth).
+	helper, err := w.startHelper(w.ctx, testcontainers.ContainerRequest{
+		Name:               fmt.Sprintf("%s-capture-%d", strings.ToLower(w.name), n),
+		Entrypoint:         []string{"tcpdump", "-i", "any", "-Q", "in", "-U", "-w", capturePath, expr},
+		HostConfigModifier: helperNetworkMode("host"),
+		WaitingFor:         wait.ForLog("listening on"),
+	})
+	if err != nil {
+		if helper != nil {
+			//nolint:errcheck
+			w.docker.ContainerRemove(w.ctx, helper.GetContainerID(), client.ContainerRemoveOptions{Force: true})
+		}
+		w.t.Fatalf("Failed to start capture %q: %v", filter, err)
+	}
+
+	label := "network"
+	if filter != "" {
+		label += " (" + filter + ")"
+	}
+	w.addCapture(&capture{
+		label:  label,
+		path:   w.worldLog.artifactPath(fmt.Sprintf("%s_capture_%d.pcap", w.name, n)),
+		helper: helper,
+	})
+}
+
+// captureHooks returns lifecycle hooks that start capturing a replica's
+// traffic as soon as it is running, so its startup is recorded too.
+func (w *World) captureHooks(pc *pendingContainer) testcontainers.ContainerLifecycleHooks {
+	return testcontainers.ContainerLifecycleHooks{
+		PostStarts: []testcontainers.ContainerHook{
+			func(ctx context.Context, c testcontainers.Container) error {
+				helper, err := w.startHelper(ctx, testcontainers.ContainerRequest{
+					Name:               pc.name + "-capture",
+					Entrypoint:         []string{"tcpdump", "-i", "any", "-U", "-w", capturePath},
+					HostConfigModifier: helperNetworkMode("container:" + c.GetContainerID()),
+					WaitingFor:         wait.ForLog("listening on"),
+				})
+				if err != nil {
+					if helper != nil {
+						pc.addSidecar(helper)
+					}
+					return fmt.Errorf("capture helper: %w", err)
+				}
+				w.addCapture(&capture{
+					label:  pc.name,
+					path:   w.worldLog.artifactPath(pc.name + ".pcap"),
+					helper: helper,
+					owner:  pc,
+				})
+				return nil
+			},
+		},
+	}
+}
+
+// addCapture registers a running capture so Destroy can save it. A replica
+// recreated by RollingUpdate gets a numbered file instead of overwriting the
+// capture of its predecessor.
+func (w *World) addCapture(c *capture) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	base := strings.TrimSuffix(c.path, ".pcap")
+	for n := 2; slices.ContainsFunc(w.captures, func(o *capture) bool { return o.path == c.path }); n++ {
+		c.path = fmt.Sprintf("%s_%d.pcap", base, n)
+	}
+	w.captures = append(w.captures, c)
+}
+
+// takeCaptures marks the unsaved captures of a replica, or of the whole world
+// if pc is nil, as saved and returns them for the caller to save.
+func (w *World) takeCaptures(pc *pendingContainer) []*capture {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var taken []*capture
+	for _, c := range w.captures {
+		if !c.saved && (pc == nil || c.owner == pc) {
+			c.saved = true
+			taken = append(taken, c)
+		}
+	}
+	return taken
+}
+
+// saveCaptures stops every capture helper, copies its pcap file into the
+// world log directory and removes the helper.
+func (w *World) saveCaptures() {
+	captures := w.takeCaptures(nil)
+
+	var wg sync.WaitGroup
+	for _, c := range captures {
+		wg.Add(1)
+		go func(c *capture) {
+			defer wg.Done()
+			if err := w.saveCapture(c); err != nil {
+				w.t.Log("Failed to save capture ", c.label, ": ", err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// saveCapture stops a single capture helper and copies out its pcap file.
+func (w *World) saveCapture(c *capture) error {
+	event := w.worldLog.newEvent("%s: save capture", c.label)
+	defer event.finish()
+	defer func() {
+		//nolint:errcheck
+		w.docker.ContainerRemove(w.ctx, c.helper.GetContainerID(), client.ContainerRemoveOptions{Force: true})
+	}()
+
+	// tcpdump flushes and closes the file on SIGTERM.
+	timeout := 5 * time.Second
+	if err := c.helper.Stop(w.ctx, &timeout); err != nil {
+		return fmt.Errorf("failed to stop capture: %w", err)
+	}
+
+	reader, err := c.helper.CopyFileFromContainer(w.ctx, capturePath)
+	if err != nil {
+		return fmt.Errorf("failed to copy capture: %w", err)
+	}
+	defer reader.Close()
+
+	f, err := os.Create(c.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, reader); err != nil {
+		return fmt.Errorf("failed to write capture: %w", err)
+	}
+	if event != nil {
+		fmt.Fprintf(event.log, "Capture written to %s\n", c.path)
+	}
+	return nil
+}
+
+// subnets returns the IPv4 subnets of the world networks as assigned by Docker.
+func (w *World) subnets(ctx context.Context) ([]netip.Prefix, error) {
+	var subnets []netip.Prefix
+	for _, n := range []*testcontainers.DockerNetwork{w.cn, w.icn} {
+		res, err := w.docker.NetworkInspect(ctx, n.ID, client.NetworkInspectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("inspect network %s: %w", n.Name, err)
+		}
+		for _, cfg := range res.Network.IPAM.Config {
+			if cfg.Subnet.Addr().Is4() {
+				subnets = append(subnets, cfg.Subnet)
+			}
+		}
+	}
+	return subnets, nil
+}
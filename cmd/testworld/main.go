@@ -0,0 +1,31 @@
+// Command testworld works with the logs written by testworld worlds.
+//
+// Usage:
+//
+//	testworld diff <a_events.log> <b_events.log>
+//
+// diff compares two combined world logs, ignoring timestamps, durations and
+// generated names, and exits with status 1 if they differ.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	testworld "github.com/AlveElde/testworld-go"
+)
+
+func main() {
+	if len(os.Args) != 4 || os.Args[1] != "diff" {
+		fmt.Fprintln(os.Stderr, "usage: testworld diff <a_events.log> <b_events.log>")
+		os.Exit(2)
+	}
+	differ, err := testworld.DiffLogs(os.Stdout, os.Args[2], os.Args[3])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "testworld:", err)
+		os.Exit(2)
+	}
+	if differ {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,162 @@
+package testworld
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// defaultConditionTimeout is how long a replica may take to meet a
+// dependency condition unless configured otherwise.
+const defaultConditionTimeout = 5 * time.Minute
+
+// depCondition is a condition a dependency must meet before dependants in
+// Requires or After proceed. Each replica is checked once and the result is
+// shared by every dependant using the same condition.
+type depCondition struct {
+	name     string
+	strategy wait.Strategy
+
+	mu      sync.Mutex
+	results map[*pendingContainer]*conditionResult
+}
+
+// conditionResult is the memoized outcome of checking one replica.
+type conditionResult struct {
+	once  sync.Once
+	event *Event
+	err   error
+}
+
+// withCondition returns a copy of wc that dependants wait on until the
+// strategy succeeds for every replica.
+func (wc *WorldContainer) withCondition(name string, strategy wait.Strategy) WorldContainer {
+	dep := *wc
+	dep.cond = &depCondition{
+		name:     name,
+		strategy: strategy,
+		results:  make(map[*pendingContainer]*conditionResult),
+	}
+	return dep
+}
+
+// Started returns wc as a dependency that is met once its replicas are
+// created and started. This is the default for Requires and After.
+func (wc *WorldContainer) Started() WorldContainer {
+	dep := *wc
+	dep.cond = nil
+	return dep
+}
+
+// Healthy returns wc as a dependency that is met once the Docker
+// healthcheck of every replica reports healthy. The healthcheck comes from
+// the image or can be set with ContainerSpec.ConfigModifier.
+func (wc *WorldContainer) Healthy() WorldContainer {
+	return wc.withCondition("healthy", wait.ForHealthCheck())
+}
+
+// CompletedSuccessfully returns wc as a dependency that is met once every
+// replica has exited with code 0.
+func (wc *WorldContainer) CompletedSuccessfully() WorldContainer {
+	return wc.withCondition("completed successfully", completedStrategy{})
+}
+
+// LogMatches returns wc as a dependency that is met once the logs of every
+// replica match the regular expression pattern.
+func (wc *WorldContainer) LogMatches(pattern string) WorldContainer {
+	return wc.withCondition(fmt.Sprintf("log matches %q", pattern), wait.ForLog(pattern).AsRegexp())
+}
+
+// Until returns wc as a dependency that is met once strategy succeeds for
+// every replica.
+func (wc *WorldContainer) Until(strategy wait.Strategy) WorldContainer {
+	return wc.withCondition(fmt.Sprintf("%T", strategy), strategy)
+}
+
+// awaitDependency blocks until every replica of the dependency is ready and
+// meets its condition, if any. It returns the events that were waited for.
+func (wc *WorldContainer) awaitDependency() ([]*Event, error) {
+	var events []*Event
+	for _, dpc := range wc.replicas() {
+		<-dpc.ready
+		events = append(events, dpc.event)
+		if dpc.err != nil {
+			return events, dpc.err
+		}
+		if wc.cond != nil {
+			event, err := wc.cond.check(wc.world, dpc)
+			events = append(events, event)
+			if err != nil {
+				return events, err
+			}
+		}
+	}
+	return events, nil
+}
+
+// check evaluates the condition for a replica the first time it is asked
+// and returns the remembered result and event afterwards.
+func (c *depCondition) check(w *World, pc *pendingContainer) (*Event, error) {
+	c.mu.Lock()
+	r, ok := c.results[pc]
+	if !ok {
+		r = &conditionResult{}
+		c.results[pc] = r
+	}
+	c.mu.Unlock()
+
+	r.once.Do(func() {
+		event := w.worldLog.newTypedEvent("condition", "%s: condition %s", pc.name, c.name)
+		defer event.finish()
+		event.dependsOn(pc.event)
+		event.setReplica(pc)
+		r.event = event
+		ctx := w.ctx
+		if timeout := w.opts.conditionTimeout; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		err := c.strategy.WaitUntilReady(ctx, pc.container)
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			// Dependants wrap the error with the dependency they waited
+			// on, so it reads as the chain of containers blocked by pc.
+			r.err = fmt.Errorf("%s did not meet condition %s within %s", pc.name, c.name, w.opts.conditionTimeout)
+		case err != nil:
+			r.err = fmt.Errorf("condition %s not met: %w", c.name, err)
+		}
+		if r.err != nil {
+			event.fail("%v", r.err)
+		}
+	})
+	return r.event, r.err
+}
+
+// completedStrategy waits for a container to exit and requires exit code 0.
+type completedStrategy struct{}
+
+// WaitUntilReady implements wait.Strategy.
+func (completedStrategy) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	for {
+		state, err := target.State(ctx)
+		if err != nil {
+			return err
+		}
+		if !state.Running && state.Status != "created" {
+			if state.ExitCode != 0 {
+				return fmt.Errorf("exited with code %d", state.ExitCode)
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package testworld
 
 import (
+	"io"
+	"net/netip"
+	"regexp"
+
 	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/network"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -17,6 +22,21 @@ type ContainerSpec struct {
 	// traffic to external networks is dropped.
 	Isolated bool
 
+	// EgressAllow restricts internet access to the listed IP addresses, CIDRs
+	// and hostnames; all other external traffic is rejected. Communication
+	// inside the world and DNS lookups are always allowed. Blocked attempts are
+	// recorded in the world log and returned by WorldContainer.DeniedEgress.
+	// The firewall runs in a helper container sharing the network namespace
+	// and is installed right after start, before WaitingFor is evaluated.
+	// Cannot be combined with Isolated.
+	EgressAllow []string
+
+	// IPv4Address pins the container's address on the internal network, which
+	// every container in the world joins. The world must be created with
+	// WithInternalSubnet and the address must lie inside that subnet.
+	// Cannot be combined with Replicas > 1.
+	IPv4Address string
+
 	// Aliases adds extra DNS aliases for this container, making it reachable
 	// by additional names from other containers in the world.
 	Aliases []string
@@ -47,12 +67,23 @@ type ContainerSpec struct {
 	// Cmd is the command to run in the container
 	Cmd []string
 
+	// ExitCode is the exit code World.RunJob and World.StartJob expect the job to exit with.
+	// It is ignored by NewContainer.
+	ExitCode int
+
 	// Env is a map of environment variables to set in the container
 	Env map[string]string
 
 	// ExposedPorts is a list of ports to expose (e.g., "80", "8080/tcp")
 	ExposedPorts []string
 
+	// ServiceNames names exposed ports for the SRV records published by the
+	// world DNS resolver (see WithDNS), keyed by "<port>/<proto>". For example,
+	// {"8080/tcp": "http"} publishes _http._tcp.<name> for the group name and
+	// each alias. Every exposed port is also published under its number, e.g.
+	// _8080._tcp.<name>.
+	ServiceNames map[string]string
+
 	// Files is a list of files to copy into the container before it starts.
 	Files []testcontainers.ContainerFile
 
@@ -78,6 +109,31 @@ type ContainerSpec struct {
 	// After dependencies, but any method blocks until they are ready.
 	After []WorldContainer
 
+	// Capture records each replica's network traffic into a pcap file next to
+	// the world log, from container start until the world is destroyed. The
+	// files are listed in the inventory. Ignored if the world has no log path.
+	Capture bool
+
+	// LogTo streams the output of every replica to the writer while it runs,
+	// each line prefixed with the replica name, e.g. "[app-2] listening".
+	// Streaming starts as soon as a replica is running, before WaitingFor is
+	// evaluated. The output is also written line by line to
+	// log_<replica>.live.log next to the world log, so it survives a test
+	// that is killed by -timeout before Destroy collects the logs.
+	LogTo io.Writer
+
+	// LogToTest is like LogTo, but streams every line through t.Log.
+	LogToTest bool
+
+	// Secrets lists values, such as passwords and tokens passed in Env or in
+	// Exec arguments, that are replaced by "[REDACTED]" in event
+	// descriptions, container output and every report the world writes.
+	// Packet captures are not redacted.
+	Secrets []string
+
+	// SecretPatterns is like Secrets, but masks every match of the patterns.
+	SecretPatterns []*regexp.Regexp
+
 	// OnDestroy is a callback function that is called before the container is terminated.
 	OnDestroy func(WorldContainer)
 }
@@ -86,6 +142,7 @@ type ContainerSpec struct {
 // All containers join the internal network so they can communicate with each other via DNS.
 // Non-isolated containers also join the external network, gaining internet access.
 // Isolated containers join only the internal network, blocking internet access.
+// A pinned IPv4Address is applied to the internal network endpoint.
 func (spec ContainerSpec) toGenericContainerRequest(name, externalNetwork, internalNetwork string, aliases []string) testcontainers.GenericContainerRequest {
 	var networks []string
 	networkAliases := map[string][]string{internalNetwork: aliases}
@@ -97,6 +154,19 @@ func (spec ContainerSpec) toGenericContainerRequest(name, externalNetwork, inter
 		networkAliases[externalNetwork] = aliases
 	}
 
+	// Only the first network receives endpoint settings at creation time;
+	// the rest are connected afterwards. Put the internal network first so
+	// the pinned address can be applied to it.
+	var endpointModifier func(map[string]*network.EndpointSettings)
+	if addr, err := netip.ParseAddr(spec.IPv4Address); err == nil {
+		networks = append([]string{internalNetwork}, networks[:len(networks)-1]...)
+		endpointModifier = func(endpoints map[string]*network.EndpointSettings) {
+			if ep := endpoints[internalNetwork]; ep != nil {
+				ep.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: addr}
+			}
+		}
+	}
+
 	cmd := spec.Cmd
 	if spec.KeepAlive && len(cmd) == 0 {
 		cmd = []string{"sleep", "infinity"}
@@ -105,20 +175,21 @@ func (spec ContainerSpec) toGenericContainerRequest(name, externalNetwork, inter
 	return testcontainers.GenericContainerRequest{
 		Started: true,
 		ContainerRequest: testcontainers.ContainerRequest{
-			FromDockerfile:     spec.FromDockerfile,
-			Image:              spec.Image,
-			Name:               name,
-			Networks:           networks,
-			NetworkAliases:     networkAliases,
-			Entrypoint:         spec.Entrypoint,
-			Cmd:                cmd,
-			Env:                spec.Env,
-			ExposedPorts:       spec.ExposedPorts,
-			Tmpfs:              spec.Tmpfs,
-			WaitingFor:         spec.WaitingFor,
-			Files:              spec.Files,
-			ConfigModifier:     spec.ConfigModifier,
-			HostConfigModifier: spec.HostConfigModifier,
+			FromDockerfile:           spec.FromDockerfile,
+			Image:                    spec.Image,
+			Name:                     name,
+			Networks:                 networks,
+			NetworkAliases:           networkAliases,
+			Entrypoint:               spec.Entrypoint,
+			Cmd:                      cmd,
+			Env:                      spec.Env,
+			ExposedPorts:             spec.ExposedPorts,
+			Tmpfs:                    spec.Tmpfs,
+			WaitingFor:               spec.WaitingFor,
+			Files:                    spec.Files,
+			ConfigModifier:           spec.ConfigModifier,
+			HostConfigModifier:       spec.HostConfigModifier,
+			EndpointSettingsModifier: endpointModifier,
 		},
 	}
 }
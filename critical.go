@@ -0,0 +1,214 @@
+package testworld
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// sinkKinds are the event kinds summarized as time sinks, in display order
+// for equal totals.
+var sinkKinds = []string{"pull", "build", "start", "wait", "condition", "exec", "job"}
+
+// phaseHooks returns lifecycle hooks that split a replica's creation event
+// into build, start and wait strategy phases. The pull phase is opened by
+// createReplica, since pulling happens before any hook runs.
+func phaseHooks(pc *pendingContainer) testcontainers.ContainerLifecycleHooks {
+	next := func(kind, what string) {
+		pc.phase.finish()
+		pc.phase = nil
+		if kind != "" {
+			pc.phase = pc.event.child(kind, "%s: %s", pc.name, what)
+		}
+	}
+	return testcontainers.ContainerLifecycleHooks{
+		PreBuilds: []testcontainers.ContainerRequestHook{
+			func(context.Context, testcontainers.ContainerRequest) error {
+				next("build", "build image")
+				return nil
+			},
+		},
+		PreCreates: []testcontainers.ContainerRequestHook{
+			func(context.Context, testcontainers.ContainerRequest) error {
+				next("start", "start")
+				return nil
+			},
+		},
+		PostStarts: []testcontainers.ContainerHook{
+			func(context.Context, testcontainers.Container) error {
+				next("wait", "wait strategy")
+				return nil
+			},
+		},
+		PostReadies: []testcontainers.ContainerHook{
+			func(context.Context, testcontainers.Container) error {
+				next("", "")
+				return nil
+			},
+		},
+	}
+}
+
+// eventFinish returns when the event finished, or end if it never did.
+func eventFinish(e *Event, end time.Time) time.Time {
+	if e.finishTime.IsZero() {
+		return end
+	}
+	return e.finishTime
+}
+
+// eventDuration returns how long the event ran, up to end if it never
+// finished.
+func eventDuration(e *Event, end time.Time) time.Duration {
+	return eventFinish(e, end).Sub(e.startTime)
+}
+
+// isRoot reports whether e is a top-level event. Steps only group events,
+// so events directly inside a step count as top-level.
+func isRoot(e *Event) bool {
+	return e.kind != "step" && (e.parent == nil || e.parent.kind == "step")
+}
+
+// rootEvent returns the top-level event e is a phase of.
+func rootEvent(e *Event) *Event {
+	for !isRoot(e) && e.parent != nil {
+		e = e.parent
+	}
+	return e
+}
+
+// criticalPath returns the chain of top-level events that determined how long
+// the world took until teardown. It starts from the last event to finish
+// before "World: destroy" and repeatedly steps to the dependency that
+// finished last, or, for events without recorded dependencies, to the event
+// that finished last before it started, which is typically the previous step
+// of the test.
+func criticalPath(events []*Event, end time.Time) []*Event {
+	teardown := end
+	for _, e := range events {
+		if e.kind == "destroy" {
+			teardown = e.startTime
+			break
+		}
+	}
+
+	var roots []*Event
+	for _, e := range events {
+		if isRoot(e) && e.startTime.Before(teardown) && e.kind != "destroy" {
+			roots = append(roots, e)
+		}
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+	latest := func(candidates []*Event, ok func(*Event) bool) *Event {
+		var best *Event
+		for _, c := range candidates {
+			if ok(c) && (best == nil || eventFinish(c, end).After(eventFinish(best, end))) {
+				best = c
+			}
+		}
+		return best
+	}
+
+	last := latest(roots, func(*Event) bool { return true })
+	path := []*Event{last}
+	seen := map[*Event]bool{last: true}
+	for e := last; ; {
+		deps := make([]*Event, 0, len(e.deps))
+		for _, d := range e.deps {
+			deps = append(deps, rootEvent(d))
+		}
+		pred := latest(deps, func(d *Event) bool { return !seen[d] })
+		if pred == nil {
+			pred = latest(roots, func(o *Event) bool {
+				return !seen[o] && !eventFinish(o, end).After(e.startTime)
+			})
+		}
+		if pred == nil {
+			break
+		}
+		seen[pred] = true
+		path = append(path, pred)
+		e = pred
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// timeSink is the total time spent in events of one kind.
+type timeSink struct {
+	kind    string
+	total   time.Duration
+	count   int
+	slowest *Event
+}
+
+// timeSinks sums event durations per kind, largest total first.
+func timeSinks(events []*Event, end time.Time) []timeSink {
+	var sinks []timeSink
+	for _, kind := range sinkKinds {
+		s := timeSink{kind: kind}
+		for _, e := range events {
+			if e.kind != kind {
+				continue
+			}
+			d := eventDuration(e, end)
+			s.total += d
+			s.count++
+			if s.slowest == nil || d > eventDuration(s.slowest, end) {
+				s.slowest = e
+			}
+		}
+		if s.count > 0 {
+			sinks = append(sinks, s)
+		}
+	}
+	slices.SortStableFunc(sinks, func(a, b timeSink) int { return cmp.Compare(b.total, a.total) })
+	return sinks
+}
+
+// printCriticalPath writes the critical path and the top time sinks to the
+// world log. Each step of the path shows its slowest phase, if any.
+func (el *WorldLog) printCriticalPath() {
+	el.rw.RLock()
+	defer el.rw.RUnlock()
+
+	path := criticalPath(el.events, el.finishTime)
+	if len(path) == 0 {
+		return
+	}
+
+	width := el.idWidth()
+	total := eventFinish(path[len(path)-1], el.finishTime).Sub(el.startTime).Seconds()
+	fmt.Fprintf(el.combinedLog, "\nCritical Path (%.3fs):\n", total)
+	for _, e := range path {
+		fmt.Fprintf(el.combinedLog, "%0*d | at %7.3fs  (%.3fs) %s\n", width, e.id,
+			e.startTime.Sub(el.startTime).Seconds(), eventDuration(e, el.finishTime).Seconds(), e.description)
+
+		var slowest *Event
+		for _, c := range el.events {
+			if c.parent == e && (slowest == nil || eventDuration(c, el.finishTime) > eventDuration(slowest, el.finishTime)) {
+				slowest = c
+			}
+		}
+		if slowest != nil {
+			fmt.Fprintf(el.combinedLog, "%*s|   └─ slowest phase (%.3fs) %s\n", width+1, "",
+				eventDuration(slowest, el.finishTime).Seconds(), slowest.description)
+		}
+	}
+
+	sinks := timeSinks(el.events, el.finishTime)
+	if len(sinks) == 0 {
+		return
+	}
+	fmt.Fprintln(el.combinedLog, "\nTop Time Sinks:")
+	for _, s := range sinks {
+		fmt.Fprintf(el.combinedLog, "  %-9s %8.3fs in %d events, slowest %.3fs %s\n", s.kind, s.total.Seconds(), s.count,
+			eventDuration(s.slowest, el.finishTime).Seconds(), s.slowest.description)
+	}
+}
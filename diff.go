@@ -0,0 +1,255 @@
+package testworld
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// logNormalizers rewrite the parts of a world log line that change from run
+// to run. They are applied in order.
+var logNormalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Gantt bars depend on timing; keep the ID and the description.
+	{regexp.MustCompile(`^(\d+) \| *\[#+\] \([\d.]+s\) `), "$1 | "},
+	{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	{regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(\.\d+)?\b`), "<time>"},
+	{regexp.MustCompile(` +\d+(\.\d+)?(ns|µs|ms|s)\b`), " <dur>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ns|µs|ms|s)\b`), "<dur>"},
+	{regexp.MustCompile(`/tmp/\S*`), "<tmp>"},
+	{regexp.MustCompile(`\b[0-9a-f]{64}\b|\b[0-9a-f]{12}\b`), "<id>"},
+	{regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}(/\d+)?\b`), "<ip>"},
+}
+
+// normalizeLog rewrites a world log so logs of different runs, or of
+// different tests, can be compared: timestamps, durations, temporary paths,
+// container IDs and addresses are replaced by placeholders, and the world
+// name by "<world>". The name is matched case-insensitively, since generated
+// container names are lowercased.
+func normalizeLog(log, world string) []string {
+	var worldRe *regexp.Regexp
+	if world != "" {
+		worldRe = regexp.MustCompile("(?i)" + regexp.QuoteMeta(world))
+	}
+	var lines []string
+	for line := range strings.Lines(log) {
+		line = strings.TrimSuffix(line, "\n")
+		for _, n := range logNormalizers {
+			line = n.re.ReplaceAllString(line, n.repl)
+		}
+		if worldRe != nil {
+			line = worldRe.ReplaceAllLiteralString(line, "<world>")
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// worldOfLog returns the world name of a combined log named
+// log_<world>_events.log, or "" for other file names.
+func worldOfLog(path string) string {
+	base := filepath.Base(path)
+	if !strings.HasPrefix(base, "log_") || !strings.HasSuffix(base, "_events.log") {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(base, "log_"), "_events.log")
+}
+
+// DiffLogs compares two combined world logs, ignoring what changes between
+// runs such as timestamps, durations and generated names, and writes the
+// differences to w in unified diff format. It reports whether the logs
+// differ.
+func DiffLogs(w io.Writer, pathA, pathB string) (bool, error) {
+	a, err := os.ReadFile(pathA)
+	if err != nil {
+		return false, err
+	}
+	b, err := os.ReadFile(pathB)
+	if err != nil {
+		return false, err
+	}
+	linesA := normalizeLog(string(a), worldOfLog(pathA))
+	linesB := normalizeLog(string(b), worldOfLog(pathB))
+	edits := diffLines(linesA, linesB)
+	if !hasChanges(edits) {
+		return false, nil
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", pathA, pathB)
+	writeHunks(w, edits)
+	return true, nil
+}
+
+// edit is a line of a diff: kept (' '), removed ('-') or added ('+'), with
+// its line numbers in both inputs.
+type edit struct {
+	op   byte
+	a, b int
+	line string
+}
+
+// hasChanges reports whether a diff contains removed or added lines.
+func hasChanges(edits []edit) bool {
+	for _, e := range edits {
+		if e.op != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// diffLines computes a shortest edit script from a to b with the
+// linear-space variant of Myers' algorithm, so diverging logs do not need
+// memory proportional to the number of edits squared.
+func diffLines(a, b []string) []edit {
+	df := &differ{a: a, b: b}
+	df.compare(0, len(a), 0, len(b))
+	return df.edits
+}
+
+// differ accumulates the edits of diffLines in order.
+type differ struct {
+	a, b  []string
+	edits []edit
+}
+
+// compare appends the edits from a[a0:a1] to b[b0:b1]. It splits the
+// problem at the middle snake of a shortest edit path and recurses on both
+// halves.
+func (df *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && df.a[a0] == df.b[b0] {
+		df.edits = append(df.edits, edit{' ', a0, b0, df.a[a0]})
+		a0++
+		b0++
+	}
+	common := 0
+	for a0 < a1 && b0 < b1 && df.a[a1-1] == df.b[b1-1] {
+		a1--
+		b1--
+		common++
+	}
+
+	switch {
+	case a0 == a1:
+		for y := b0; y < b1; y++ {
+			df.edits = append(df.edits, edit{'+', a0, y, df.b[y]})
+		}
+	case b0 == b1:
+		for x := a0; x < a1; x++ {
+			df.edits = append(df.edits, edit{'-', x, b0, df.a[x]})
+		}
+	default:
+		// With the common prefix and suffix removed, at least two edits
+		// remain, so both halves are smaller problems.
+		x, y, u, v := df.middleSnake(a0, a1, b0, b1)
+		df.compare(a0, x, b0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			df.edits = append(df.edits, edit{' ', x, y, df.a[x]})
+		}
+		df.compare(u, a1, v, b1)
+	}
+
+	for i := range common {
+		df.edits = append(df.edits, edit{' ', a1 + i, b1 + i, df.a[a1+i]})
+	}
+}
+
+// middleSnake searches from both ends of a[a0:a1] and b[b0:b1] at once until
+// the paths overlap, and returns the start (x, y) and end (u, v) of the
+// snake where they meet.
+func (df *differ) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	// vf holds the furthest x reached forward on each diagonal k = x - y,
+	// vb the furthest x reached backward on each diagonal of the reversed
+	// inputs, which is diagonal delta - k of the inputs.
+	vf := make([]int, 2*maxD+3)
+	vb := make([]int, 2*maxD+3)
+
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && df.a[a0+x] == df.b[b0+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && x+vb[offset+rk] >= n {
+				return a0 + sx, b0 + sy, a0 + x, b0 + y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && df.a[a1-1-x] == df.b[b1-1-y] {
+				x++
+				y++
+			}
+			vb[offset+k] = x
+			if fk := delta - k; !odd && fk >= -d && fk <= d && x+vf[offset+fk] >= n {
+				return a1 - x, b1 - y, a1 - sx, b1 - sy
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// writeHunks writes the changed lines with diffContext lines of context,
+// grouped into unified diff hunks.
+func writeHunks(w io.Writer, edits []edit) {
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while changes are close enough to share context.
+		start := max(i-diffContext, 0)
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext+1, len(edits))
+
+		var countA, countB int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", edits[start].a+1, countA, edits[start].b+1, countB)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(w, "%c%s\n", e.op, e.line)
+		}
+		i = end
+	}
+}
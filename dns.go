@@ -0,0 +1,568 @@
+package testworld
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/mount"
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSPolicy selects how the world DNS resolver handles names that are not
+// world aliases.
+type DNSPolicy int
+
+const (
+	// DNSForward forwards other names to the host's resolver.
+	DNSForward DNSPolicy = iota + 1
+	// DNSRefuse answers other names with REFUSED.
+	DNSRefuse
+)
+
+// Results reported in DNSQuery.Result.
+const (
+	DNSAnswered  = "answered"  // answered from world records
+	DNSForwarded = "forwarded" // forwarded to the host's resolver
+	DNSRefused   = "refused"   // refused by DNSRefuse
+	DNSFailed    = "failed"    // malformed query or upstream failure
+)
+
+const (
+	// dnsVolumePath is where the relay mounts the resolver volume.
+	dnsVolumePath = "/world"
+	// dnsReady is printed by the relay once it is listening.
+	dnsReady = "testworld: dns relay ready"
+)
+
+// DNSQuery is a single query observed by the world DNS resolver.
+type DNSQuery struct {
+	Time   time.Time
+	Name   string // queried name, lower-case without the trailing dot
+	Type   string // query type, e.g. "A", "AAAA" or "SRV"
+	Result string // one of DNSAnswered, DNSForwarded, DNSRefused or DNSFailed
+}
+
+// worldDNS is the opt-in world DNS resolver. It runs in the test process and
+// is reached through a relay container on the world networks, whose address
+// is written into every container's /etc/resolv.conf. Queries for world
+// aliases are answered from the registered container addresses; other names
+// are forwarded or refused depending on the policy.
+type worldDNS struct {
+	world    *World
+	policy   DNSPolicy
+	upstream string // host resolver used by DNSForward, "" if unknown
+	udp      net.PacketConn
+	tcp      net.Listener
+	relay    testcontainers.Container
+	addr     netip.Addr // address of the relay in resolv.conf
+	volume   string     // Docker volume holding resolv.conf
+
+	mu      sync.Mutex
+	entries map[*pendingContainer]*dnsEntry
+	hosts   map[string][]netip.Addr // lower-case name -> addresses, rebuilt from entries
+	srv     map[string][]*net.SRV   // lower-case SRV name -> records, rebuilt from entries
+	queries []DNSQuery
+}
+
+// dnsEntry holds the records contributed by a single replica.
+type dnsEntry struct {
+	pc     *pendingContainer
+	group  string        // group name, used for ordering
+	index  int           // replica number within the group, used for ordering
+	addr   netip.Addr    // address on the internal network
+	shared []string      // names shared by all replicas of the group
+	ports  []servicePort // exposed ports published as SRV records
+}
+
+// servicePort is an exposed port published under one or more service names.
+type servicePort struct {
+	names []string // e.g. "8080" and "http"
+	proto string   // "tcp" or "udp"
+	port  uint16
+}
+
+// newWorldDNS starts listening for relayed queries on a random port of addr,
+// using the same port number for UDP and TCP.
+func newWorldDNS(w *World, policy DNSPolicy, addr netip.Addr) (*worldDNS, error) {
+	d := &worldDNS{
+		world:    w,
+		policy:   policy,
+		upstream: hostResolver("/etc/resolv.conf"),
+		volume:   strings.ToLower(w.name) + "-dns",
+		entries:  make(map[*pendingContainer]*dnsEntry),
+	}
+
+	var err error
+	for range 10 {
+		if d.udp, err = net.ListenPacket("udp4", netip.AddrPortFrom(addr, 0).String()); err != nil {
+			return nil, err
+		}
+		port := uint16(d.udp.LocalAddr().(*net.UDPAddr).Port)
+		if d.tcp, err = net.Listen("tcp4", netip.AddrPortFrom(addr, port).String()); err == nil {
+			break
+		}
+		d.udp.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	go d.serveUDP()
+	go d.serveTCP()
+	return d, nil
+}
+
+// listenDNS starts the resolver on the address the relay reaches through
+// host-gateway, the gateway of Docker's default bridge network, so it is not
+// exposed on the host's other interfaces. If that address is not local, as
+// when Docker runs in a VM, it falls back to the loopback address, which the
+// VM forwards host.docker.internal to.
+func (w *World) listenDNS(ctx context.Context, policy DNSPolicy) (*worldDNS, error) {
+	res, err := w.docker.NetworkInspect(ctx, "bridge", client.NetworkInspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("inspect bridge network: %w", err)
+	}
+	for _, cfg := range res.Network.IPAM.Config {
+		if cfg.Gateway.Is4() {
+			if d, err := newWorldDNS(w, policy, cfg.Gateway); err == nil {
+				return d, nil
+			}
+		}
+	}
+	return newWorldDNS(w, policy, netip.AddrFrom4([4]byte{127, 0, 0, 1}))
+}
+
+// start runs the relay container and publishes its address in resolv.conf.
+func (d *worldDNS) start(ctx context.Context) error {
+	w := d.world
+	port := d.udp.LocalAddr().(*net.UDPAddr).Port
+	script := fmt.Sprintf("socat -T5 UDP4-RECVFROM:53,fork UDP4-SENDTO:host.docker.internal:%d &\n"+
+		"echo %q\n"+
+		"exec socat TCP4-LISTEN:53,fork,reuseaddr TCP4:host.docker.internal:%d\n", port, dnsReady, port)
+
+	relay, err := w.startHelper(ctx, testcontainers.ContainerRequest{
+		Name:       strings.ToLower(w.name) + "-dns",
+		Entrypoint: []string{"sh", "-c", script},
+		Networks:   []string{w.icn.Name, w.cn.Name},
+		HostConfigModifier: func(hc *container.HostConfig) {
+			hc.ExtraHosts = append(hc.ExtraHosts, "host.docker.internal:host-gateway")
+		},
+		Mounts: testcontainers.ContainerMounts{
+			testcontainers.VolumeMount(d.volume, testcontainers.ContainerMountTarget(dnsVolumePath)),
+		},
+		WaitingFor: wait.ForLog(dnsReady),
+	})
+	if relay != nil {
+		d.relay = relay
+	}
+	if err != nil {
+		return fmt.Errorf("dns relay: %w", err)
+	}
+
+	addr, err := internalAddress(ctx, relay, w.icn.Name)
+	if err != nil {
+		return fmt.Errorf("dns relay: %w", err)
+	}
+	d.addr = addr
+	resolv := fmt.Sprintf("nameserver %s\noptions ndots:0\n", addr)
+	if err := relay.CopyToContainer(ctx, []byte(resolv), dnsVolumePath+"/resolv.conf", 0o644); err != nil {
+		return fmt.Errorf("write resolv.conf: %w", err)
+	}
+	return nil
+}
+
+// mount returns the mount that replaces a container's /etc/resolv.conf with
+// the one pointing at the relay.
+func (d *worldDNS) mount() testcontainers.ContainerMount {
+	return testcontainers.ContainerMount{
+		Source: testcontainers.DockerVolumeMountSource{
+			Name:          d.volume,
+			VolumeOptions: &mount.VolumeOptions{Subpath: "resolv.conf"},
+		},
+		Target:   "/etc/resolv.conf",
+		ReadOnly: true,
+	}
+}
+
+// hooks returns lifecycle hooks that publish a replica's records as soon as
+// it is running, so the replica and its peers can resolve its aliases while
+// its wait strategy is evaluated, like with Docker's embedded DNS.
+func (d *worldDNS) hooks(pc *pendingContainer, tmpl *replicaTemplate) testcontainers.ContainerLifecycleHooks {
+	return testcontainers.ContainerLifecycleHooks{
+		PostStarts: []testcontainers.ContainerHook{
+			func(ctx context.Context, c testcontainers.Container) error {
+				if err := d.register(ctx, pc, c, tmpl.name, pc.index, tmpl.sharedNames, tmpl.ports); err != nil {
+					return fmt.Errorf("register DNS records: %w", err)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// register publishes the records of a replica running in container c: an A
+// record for each of its aliases and SRV records for its exposed ports under
+// each of the group's shared names.
+func (d *worldDNS) register(ctx context.Context, pc *pendingContainer, c testcontainers.Container, group string, index int, shared []string, ports []servicePort) error {
+	addr, err := internalAddress(ctx, c, d.world.icn.Name)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[pc] = &dnsEntry{pc: pc, group: group, index: index, addr: addr, shared: shared, ports: ports}
+	d.rebuild()
+	return nil
+}
+
+// unregister withdraws a replica's records.
+func (d *worldDNS) unregister(pc *pendingContainer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, pc)
+	d.rebuild()
+}
+
+// rebuild recomputes the record set from the registered replicas. Records
+// are ordered by group and replica number, so a group name resolves to its
+// replicas in a stable order. The caller must hold d.mu.
+func (d *worldDNS) rebuild() {
+	entries := slices.SortedFunc(maps.Values(d.entries), func(a, b *dnsEntry) int {
+		return cmp.Or(cmp.Compare(a.group, b.group), cmp.Compare(a.index, b.index))
+	})
+
+	d.hosts = make(map[string][]netip.Addr)
+	d.srv = make(map[string][]*net.SRV)
+	for _, e := range entries {
+		for _, alias := range e.pc.aliases {
+			name := strings.ToLower(alias)
+			d.hosts[name] = append(d.hosts[name], e.addr)
+		}
+		for _, sp := range e.ports {
+			for _, svc := range sp.names {
+				for _, shared := range e.shared {
+					name := strings.ToLower(fmt.Sprintf("_%s._%s.%s", svc, sp.proto, shared))
+					d.srv[name] = append(d.srv[name], &net.SRV{Target: e.pc.name, Port: sp.port, Priority: 0, Weight: 1})
+				}
+			}
+		}
+	}
+}
+
+// servicePorts parses ExposedPorts entries ("8080", "8080/tcp",
+// "127.0.0.1:8080:8080/udp") into ports published as SRV records. Every port
+// is published under its number, plus the name given in names, which is keyed
+// by "<port>/<proto>".
+func servicePorts(exposed []string, names map[string]string) []servicePort {
+	var ports []servicePort
+	for _, e := range exposed {
+		if i := strings.LastIndex(e, ":"); i >= 0 {
+			e = e[i+1:]
+		}
+		num, proto, _ := strings.Cut(e, "/")
+		if proto == "" {
+			proto = "tcp"
+		}
+		port, err := strconv.ParseUint(num, 10, 16)
+		if err != nil {
+			continue
+		}
+		sp := servicePort{names: []string{num}, proto: proto, port: uint16(port)}
+		if name := names[num+"/"+proto]; name != "" {
+			sp.names = append(sp.names, name)
+		} else if name := names[num]; name != "" && proto == "tcp" {
+			sp.names = append(sp.names, name)
+		}
+		ports = append(ports, sp)
+	}
+	return ports
+}
+
+// close stops the resolver and removes the relay and its volume. It must be
+// called after all containers mounting the volume have been removed.
+func (d *worldDNS) close(ctx context.Context) {
+	d.udp.Close()
+	d.tcp.Close()
+	if d.relay != nil {
+		//nolint:errcheck
+		d.world.docker.ContainerRemove(ctx, d.relay.GetContainerID(), client.ContainerRemoveOptions{Force: true})
+	}
+	//nolint:errcheck
+	d.world.docker.VolumeRemove(ctx, d.volume, client.VolumeRemoveOptions{Force: true})
+}
+
+// serveUDP answers relayed UDP queries until the socket is closed.
+func (d *worldDNS) serveUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := d.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := d.handle(req); resp != nil {
+				//nolint:errcheck
+				d.udp.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+// serveTCP answers relayed TCP queries until the listener is closed.
+func (d *worldDNS) serveTCP() {
+	for {
+		conn, err := d.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				var size uint16
+				if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+					return
+				}
+				req := make([]byte, size)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				resp := d.handle(req)
+				if resp == nil {
+					return
+				}
+				if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))); err != nil {
+					return
+				}
+				if _, err := conn.Write(resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle answers a single DNS message and records the query.
+func (d *worldDNS) handle(req []byte) []byte {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(req)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		d.record("", "", DNSFailed, "")
+		return reply(hdr, nil, dnsmessage.RCodeFormatError, nil)
+	}
+	name := strings.ToLower(strings.TrimSuffix(q.Name.String(), "."))
+	qtype := strings.TrimPrefix(q.Type.String(), "Type")
+
+	d.mu.Lock()
+	addrs, isHost := d.hosts[name]
+	srvs, isSRV := d.srv[name]
+	var answers []dnsmessage.Resource
+	if q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL {
+		for _, a := range addrs {
+			answers = append(answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.AResource{A: a.As4()},
+			})
+		}
+	}
+	if q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeALL {
+		for _, srv := range srvs {
+			answers = append(answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET},
+				Body: &dnsmessage.SRVResource{
+					Priority: srv.Priority,
+					Weight:   srv.Weight,
+					Port:     srv.Port,
+					Target:   dnsmessage.MustNewName(srv.Target + "."),
+				},
+			})
+		}
+	}
+	d.mu.Unlock()
+
+	if isHost || isSRV {
+		d.record(name, qtype, DNSAnswered, fmt.Sprint(len(answers), " answers"))
+		return reply(hdr, &q, dnsmessage.RCodeSuccess, answers)
+	}
+
+	if d.policy == DNSRefuse || d.upstream == "" {
+		d.record(name, qtype, DNSRefused, "")
+		return reply(hdr, &q, dnsmessage.RCodeRefused, nil)
+	}
+
+	resp, err := forwardDNS(d.upstream, req)
+	if err != nil {
+		d.record(name, qtype, DNSFailed, err.Error())
+		return reply(hdr, &q, dnsmessage.RCodeServerFailure, nil)
+	}
+	d.record(name, qtype, DNSForwarded, d.upstream)
+	return resp
+}
+
+// record stores a query and logs it as a world event.
+func (d *worldDNS) record(name, qtype, result, detail string) {
+	d.mu.Lock()
+	d.queries = append(d.queries, DNSQuery{Time: time.Now(), Name: name, Type: qtype, Result: result})
+	d.mu.Unlock()
+
+	event := d.world.worldLog.newEvent("DNS: %s %s %s", qtype, name, result)
+	if event != nil && detail != "" {
+		fmt.Fprintln(event.log, detail)
+	}
+	event.finish()
+}
+
+// reply builds a response to hdr with the given question, rcode and answers.
+func reply(hdr dnsmessage.Header, q *dnsmessage.Question, rcode dnsmessage.RCode, answers []dnsmessage.Resource) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		Authoritative:      rcode == dnsmessage.RCodeSuccess,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	if q != nil {
+		if b.StartQuestions() != nil || b.Question(*q) != nil {
+			return nil
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil
+	}
+	for _, a := range answers {
+		var err error
+		switch body := a.Body.(type) {
+		case *dnsmessage.AResource:
+			err = b.AResource(a.Header, *body)
+		case *dnsmessage.SRVResource:
+			err = b.SRVResource(a.Header, *body)
+		}
+		if err != nil {
+			return nil
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// forwardDNS sends a query to upstream over UDP and returns the raw response.
+func forwardDNS(upstream string, req []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", upstream, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	//nolint:errcheck
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// hostResolver returns the first nameserver in a resolv.conf file as
+// "host:53", or "" if there is none.
+func hostResolver(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return ""
+}
+
+// internalAddress returns a container's IPv4 address on the named network.
+func internalAddress(ctx context.Context, c testcontainers.Container, network string) (netip.Addr, error) {
+	info, err := c.Inspect(ctx)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("inspect: %w", err)
+	}
+	if info.NetworkSettings != nil {
+		if ep := info.NetworkSettings.Networks[network]; ep != nil && ep.IPAddress.IsValid() {
+			return ep.IPAddress, nil
+		}
+	}
+	return netip.Addr{}, errors.New("container has no address on the internal network")
+}
+
+// LookupHost returns the addresses the world DNS resolver currently returns
+// for name, in replica order. It returns nil unless the world was created
+// with WithDNS.
+func (w *World) LookupHost(name string) []netip.Addr {
+	if w.dns == nil {
+		return nil
+	}
+	w.dns.mu.Lock()
+	defer w.dns.mu.Unlock()
+	return slices.Clone(w.dns.hosts[strings.ToLower(strings.TrimSuffix(name, "."))])
+}
+
+// LookupSRV returns the SRV records the world DNS resolver currently
+// publishes for _service._proto.name, in replica order. Every exposed port is
+// published under its number (e.g. service "8080") and under its name from
+// ContainerSpec.ServiceNames. It returns nil unless the world was created
+// with WithDNS.
+func (w *World) LookupSRV(service, proto, name string) []*net.SRV {
+	if w.dns == nil {
+		return nil
+	}
+	key := strings.ToLower(fmt.Sprintf("_%s._%s.%s", service, proto, strings.TrimSuffix(name, ".")))
+	w.dns.mu.Lock()
+	defer w.dns.mu.Unlock()
+	var records []*net.SRV
+	for _, srv := range w.dns.srv[key] {
+		c := *srv
+		records = append(records, &c)
+	}
+	return records
+}
+
+// DNSQueries returns every query observed by the world DNS resolver so far,
+// in arrival order. It returns nil unless the world was created with WithDNS.
+func (w *World) DNSQueries() []DNSQuery {
+	if w.dns == nil {
+		return nil
+	}
+	w.dns.mu.Lock()
+	defer w.dns.mu.Unlock()
+	return append([]DNSQuery(nil), w.dns.queries...)
+}
@@ -0,0 +1,218 @@
+package testworld
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// egressReady is printed by the egress helper once the firewall is in place.
+const egressReady = "testworld: egress rules applied"
+
+// validateEgressAllow checks that every allowlist entry is an IP address,
+// a CIDR or a hostname. Entries end up in a shell script, so anything else
+// is rejected.
+func validateEgressAllow(entries []string) error {
+	for _, e := range entries {
+		if _, err := netip.ParsePrefix(e); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(e); err == nil {
+			continue
+		}
+		if !isHostname(e) {
+			return fmt.Errorf("egress entry %q is not an IP address, CIDR or hostname", e)
+		}
+	}
+	return nil
+}
+
+// isHostname reports whether s is a valid DNS hostname: dot-separated labels
+// of letters, digits and inner hyphens.
+func isHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for label := range strings.SplitSeq(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// egressScript builds the helper's shell script. Traffic to loopback, the
+// world DNS resolver at dns (if any), directly connected (world) subnets and
+// the allowlist is accepted. Everything else is logged to NFLOG group 1,
+// which tcpdump prints, and rejected. IPv6 gets the same policy, so it cannot
+// bypass the allowlist; hostnames without IPv6 addresses allow no IPv6
+// traffic.
+func egressScript(allow []string, dns netip.Addr) string {
+	var v4, v6, hosts []string
+	for _, a := range allow {
+		addr, err := netip.ParseAddr(a)
+		if p, perr := netip.ParsePrefix(a); perr == nil {
+			addr, err = p.Addr(), nil
+		}
+		switch {
+		case err != nil:
+			hosts = append(hosts, a)
+		case addr.Is4():
+			v4 = append(v4, a)
+		default:
+			v6 = append(v6, a)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	b.WriteString("iptables -A OUTPUT -o lo -j ACCEPT\n")
+	b.WriteString("iptables -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n")
+	// Docker's embedded resolver is reached through loopback.
+	if dns.IsValid() {
+		fmt.Fprintf(&b, "iptables -A OUTPUT -d %s -p udp --dport 53 -j ACCEPT\n", dns)
+		fmt.Fprintf(&b, "iptables -A OUTPUT -d %s -p tcp --dport 53 -j ACCEPT\n", dns)
+	}
+	b.WriteString("for net in $(ip -4 route show scope link | awk '{print $1}'); do iptables -A OUTPUT -d \"$net\" -j ACCEPT; done\n")
+	for _, a := range append(v4, hosts...) {
+		fmt.Fprintf(&b, "iptables -A OUTPUT -d %s -j ACCEPT\n", a)
+	}
+	b.WriteString("iptables -A OUTPUT -j NFLOG --nflog-group 1\n")
+	b.WriteString("iptables -A OUTPUT -j REJECT\n")
+
+	b.WriteString("if [ -e /proc/net/if_inet6 ]; then\n")
+	b.WriteString("ip6tables -A OUTPUT -o lo -j ACCEPT\n")
+	b.WriteString("ip6tables -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n")
+	// Neighbor discovery needs ICMPv6.
+	b.WriteString("ip6tables -A OUTPUT -p ipv6-icmp -j ACCEPT\n")
+	for _, a := range v6 {
+		fmt.Fprintf(&b, "ip6tables -A OUTPUT -d %s -j ACCEPT\n", a)
+	}
+	for _, h := range hosts {
+		fmt.Fprintf(&b, "ip6tables -A OUTPUT -d %s -j ACCEPT 2>/dev/null || true\n", h)
+	}
+	b.WriteString("ip6tables -A OUTPUT -j NFLOG --nflog-group 1\n")
+	b.WriteString("ip6tables -A OUTPUT -j REJECT\n")
+	b.WriteString("fi\n")
+
+	fmt.Fprintf(&b, "echo %q\n", egressReady)
+	b.WriteString("exec tcpdump -l -n -q -i nflog:1\n")
+	return b.String()
+}
+
+// egressHooks returns lifecycle hooks that start the egress helper as soon
+// as the container is running, before its wait strategy is evaluated.
+func (w *World) egressHooks(pc *pendingContainer, allow []string) testcontainers.ContainerLifecycleHooks {
+	return testcontainers.ContainerLifecycleHooks{
+		PostStarts: []testcontainers.ContainerHook{
+			func(ctx context.Context, c testcontainers.Container) error {
+				return w.startEgressHelper(ctx, pc, c.GetContainerID(), allow)
+			},
+		},
+	}
+}
+
+// startEgressHelper runs a privileged helper in the network namespace of the
+// container with the given ID. The helper installs the firewall and then
+// reports every rejected packet on stdout.
+func (w *World) startEgressHelper(ctx context.Context, pc *pendingContainer, id string, allow []string) error {
+	var dns netip.Addr
+	if w.dns != nil {
+		dns = w.dns.addr
+	}
+	helper, err := w.startHelper(ctx, testcontainers.ContainerRequest{
+		Name:               pc.name + "-egress",
+		Entrypoint:         []string{"sh", "-c", egressScript(allow, dns)},
+		HostConfigModifier: helperNetworkMode("container:" + id),
+		WaitingFor:         wait.ForLog(egressReady),
+		LogConsumerCfg: &testcontainers.LogConsumerConfig{
+			Consumers: []testcontainers.LogConsumer{&egressConsumer{world: w, pc: pc}},
+		},
+	})
+	if helper != nil {
+		pc.addSidecar(helper)
+	}
+	if err != nil {
+		return fmt.Errorf("egress helper: %w", err)
+	}
+	return nil
+}
+
+// egressConsumer turns tcpdump output from the egress helper into denial
+// records and world log events.
+type egressConsumer struct {
+	world *World
+	pc    *pendingContainer
+}
+
+// Accept implements testcontainers.LogConsumer.
+func (ec *egressConsumer) Accept(l testcontainers.Log) {
+	for line := range strings.Lines(string(l.Content)) {
+		dst, ok := parseDeniedPacket(line)
+		if !ok {
+			continue
+		}
+		ec.pc.mu.Lock()
+		ec.pc.denied = append(ec.pc.denied, dst)
+		ec.pc.mu.Unlock()
+
+		event := ec.world.worldLog.newEvent("%s: egress denied %s", ec.pc.name, dst)
+		event.setReplica(ec.pc)
+		if event != nil {
+			fmt.Fprintf(event.log, "%s %s", time.Now().Format(time.RFC3339Nano), line)
+		}
+		event.finish()
+	}
+}
+
+// parseDeniedPacket extracts the destination from a tcpdump line such as
+// "12:00:00.000000 IP 10.0.0.2.41234 > 93.184.216.34.443: tcp 0", returning
+// "93.184.216.34:443", or "[2001:db8::1]:443" for IPv6. Destinations without
+// a port (e.g. ICMP) are returned as a bare address.
+func parseDeniedPacket(line string) (string, bool) {
+	_, rest, ok := strings.Cut(line, " > ")
+	if !ok {
+		return "", false
+	}
+	dst, _, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return "", false
+	}
+	if addr, err := netip.ParseAddr(dst); err == nil {
+		return addr.String(), true
+	}
+	i := strings.LastIndexByte(dst, '.')
+	if i < 0 {
+		return "", false
+	}
+	ap, err := netip.ParseAddrPort(net.JoinHostPort(dst[:i], dst[i+1:]))
+	if err != nil {
+		return "", false
+	}
+	return ap.String(), true
+}
+
+// DeniedEgress returns the destinations ("ip:port", or "ip" for protocols
+// without ports) that replicas of this container tried to reach but were
+// blocked by the EgressAllow allowlist. Denials are reported as the helper
+// observes them, so a just-failed connection may take a moment to appear.
+func (wc *WorldContainer) DeniedEgress() []string {
+	var denied []string
+	for _, pc := range wc.replicas() {
+		pc.mu.Lock()
+		denied = append(denied, pc.denied...)
+		pc.mu.Unlock()
+	}
+	return denied
+}
@@ -0,0 +1,92 @@
+package testworld
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultFailureTail is the number of lines WithFailureLogs prints per output
+// unless configured otherwise.
+const defaultFailureTail = 50
+
+// logFailures prints the tail of every replica's output and of every failed
+// operation through t.Log, one message per replica, so a failed test can be
+// diagnosed from the test output alone.
+func (el *WorldLog) logFailures(tail int) {
+	if el == nil || el.world == nil {
+		return
+	}
+	for _, report := range el.failureReports(tail) {
+		el.world.t.Log(report)
+	}
+}
+
+// failureReports returns one message per replica with the tail of its output
+// and of its failed operations, in the order the replicas appear in the log.
+func (el *WorldLog) failureReports(tail int) []string {
+	el.rw.RLock()
+	var replicas []string
+	sections := make(map[string][]string)
+	for _, e := range eventTree(el.events) {
+		output := e.kind == "logs" || e.kind == "job"
+		if e.replica == "" || (!output && e.failure == "") {
+			continue
+		}
+		data, err := el.readEventLog(e)
+		if err != nil {
+			continue
+		}
+		lines, skipped := tailLines(eventOutputLines(string(data)), tail)
+
+		var b strings.Builder
+		switch {
+		case e.failure != "":
+			fmt.Fprintf(&b, "%s: %s", e.description, e.failure)
+		default:
+			b.WriteString("output")
+		}
+		if skipped > 0 {
+			fmt.Fprintf(&b, " (last %d of %d lines)", len(lines), len(lines)+skipped)
+		}
+		b.WriteString(":\n")
+		for _, l := range lines {
+			b.WriteString("    " + l + "\n")
+		}
+
+		if _, ok := sections[e.replica]; !ok {
+			replicas = append(replicas, e.replica)
+		}
+		sections[e.replica] = append(sections[e.replica], b.String())
+	}
+	el.rw.RUnlock()
+
+	reports := make([]string, len(replicas))
+	for i, r := range replicas {
+		reports[i] = fmt.Sprintf("=== %s ===\n%s", r, strings.Join(sections[r], ""))
+	}
+	return reports
+}
+
+// eventOutputLines returns the lines of an event log without the start and
+// finish lines added by the world log.
+func eventOutputLines(log string) []string {
+	if log == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(log, "\n"), "\n")
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "Event ") && strings.Contains(lines[0], " start: ") {
+		lines = lines[1:]
+	}
+	if n := len(lines); n > 0 && strings.HasPrefix(lines[n-1], "Event ") && strings.Contains(lines[n-1], " finish: ") {
+		lines = lines[:n-1]
+	}
+	return lines
+}
+
+// tailLines returns the last n lines and how many lines were left out.
+func tailLines(lines []string, n int) ([]string, int) {
+	if len(lines) <= n {
+		return lines, 0
+	}
+	return lines[len(lines)-n:], len(lines) - n
+}
@@ -0,0 +1,131 @@
+package testworld
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// follower streams the output of a running replica, see ContainerSpec.LogTo.
+type follower struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// followHooks returns lifecycle hooks that start following the replica's
+// output as soon as it is running, so output of replicas that never become
+// ready is streamed too.
+func (w *World) followHooks(pc *pendingContainer, spec ContainerSpec) testcontainers.ContainerLifecycleHooks {
+	return testcontainers.ContainerLifecycleHooks{
+		PostStarts: []testcontainers.ContainerHook{
+			func(_ context.Context, c testcontainers.Container) error {
+				w.followLogs(pc, c.GetContainerID(), spec.LogTo, spec.LogToTest)
+				return nil
+			},
+		},
+	}
+}
+
+// followLogs streams the output of the container with the given ID line by
+// line until it exits or stopFollowing is called. Lines go to to and t.Log,
+// prefixed with the replica name, and to log_<replica>.live.log next to the
+// world log, which is written as lines arrive so it survives a killed test.
+func (w *World) followLogs(pc *pendingContainer, id string, to io.Writer, toTest bool) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	reader, err := w.docker.ContainerLogs(ctx, id, client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		cancel()
+		w.t.Log("Failed to follow logs of ", pc.name, ": ", err)
+		return
+	}
+
+	var live *os.File
+	if w.worldLog.dir != "" {
+		path := w.worldLog.artifactPath(pc.name + ".live.log")
+		if live, err = os.Create(path); err != nil {
+			w.t.Log("Failed to create live log for ", pc.name, ": ", err)
+		} else {
+			w.worldLog.track(path)
+		}
+	}
+
+	prefix := "[" + pc.name + "] "
+	lw := &lineWriter{emit: func(line []byte) {
+		line = []byte(w.secrets.redact(string(line)))
+		if live != nil {
+			live.Write(line)
+		}
+		if to != nil {
+			w.streamMu.Lock()
+			to.Write(append([]byte(prefix), line...))
+			w.streamMu.Unlock()
+		}
+		if toTest {
+			w.t.Log(prefix + string(bytes.TrimSuffix(line, []byte("\n"))))
+		}
+	}}
+
+	f := &follower{cancel: cancel, done: make(chan struct{})}
+	pc.mu.Lock()
+	pc.follower = f
+	pc.mu.Unlock()
+	go func() {
+		defer close(f.done)
+		defer reader.Close()
+		stdcopy.StdCopy(lw, lw, reader)
+		lw.flush()
+		if live != nil {
+			live.Close()
+		}
+	}()
+}
+
+// stopFollowing stops streaming the replica's output and waits until the
+// lines received so far are written.
+func (pc *pendingContainer) stopFollowing() {
+	pc.mu.Lock()
+	f := pc.follower
+	pc.follower = nil
+	pc.mu.Unlock()
+	if f != nil {
+		f.cancel()
+		<-f.done
+	}
+}
+
+// lineWriter splits written bytes into lines, passing each complete line
+// including its newline to emit.
+type lineWriter struct {
+	buf  []byte
+	emit func(line []byte)
+}
+
+// Write implements io.Writer.
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		lw.emit(lw.buf[:i+1])
+		lw.buf = lw.buf[i+1:]
+	}
+}
+
+// flush emits a final line without a newline, terminating it.
+func (lw *lineWriter) flush() {
+	if len(lw.buf) > 0 {
+		lw.emit(append(lw.buf, '\n'))
+		lw.buf = nil
+	}
+}
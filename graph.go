@@ -0,0 +1,173 @@
+package testworld
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultAwaitReport is how long an Await may block before the chain of
+// containers blocking it is logged.
+const defaultAwaitReport = 30 * time.Second
+
+// depEdge is a Requires or After dependency between two container groups.
+type depEdge struct {
+	from      string        // dependant group name
+	to        string        // dependency group name
+	kind      string        // "requires" or "after"
+	condition string        // condition name, empty for started
+	waited    time.Duration // longest time a dependant waited for it
+}
+
+// addDependencies validates the Requires and After dependencies of a new
+// container group and adds them to the world's dependency graph. A dependency
+// must be a container of this world. As it must exist before its dependants,
+// the graph cannot contain cycles.
+func (w *World) addDependencies(name string, spec ContainerSpec) error {
+	var edges []depEdge
+	for _, group := range []struct {
+		kind string
+		deps []WorldContainer
+	}{{"requires", spec.Requires}, {"after", spec.After}} {
+		kind := group.kind
+		for _, dep := range group.deps {
+			if dep.group == nil {
+				return fmt.Errorf("%s dependency %q was not created with NewContainer, RunJob or StartJob", kind, dep.Name)
+			}
+			if dep.world != w {
+				return fmt.Errorf("%s dependency %s belongs to another world", kind, dep.Name)
+			}
+			edge := depEdge{from: name, to: dep.Name, kind: kind}
+			if dep.cond != nil {
+				edge.condition = dep.cond.name
+			}
+			edges = append(edges, edge)
+		}
+	}
+
+	// recordWait updates the edges from creation goroutines.
+	w.mu.Lock()
+	w.deps = append(w.deps, edges...)
+	w.mu.Unlock()
+	return nil
+}
+
+// recordWait notes that a dependant waited d for one of its dependencies.
+// Each edge keeps the longest wait, which is what delayed the dependant.
+func (w *World) recordWait(from, to, kind string, d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range w.deps {
+		e := &w.deps[i]
+		if e.from == from && e.to == to && e.kind == kind {
+			e.waited = max(e.waited, d)
+		}
+	}
+}
+
+// dependencies returns a snapshot of the dependency graph.
+func (w *World) dependencies() []depEdge {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.deps)
+}
+
+// label describes an edge, e.g. "requires (healthy), waited 1.204s".
+func (e depEdge) label() string {
+	s := e.kind
+	if e.condition != "" {
+		s += " (" + e.condition + ")"
+	}
+	return fmt.Sprintf("%s, waited %.3fs", s, e.waited.Seconds())
+}
+
+// dependencyDOT renders the dependency graph in Graphviz DOT format. Edges
+// point from the dependant to its dependency; After edges are dashed.
+func dependencyDOT(title string, nodes []string, edges []depEdge) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", title)
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "  %q;\n", n)
+	}
+	for _, e := range edges {
+		style := "solid"
+		if e.kind == "after" {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "  %q -> %q [label=%q, style=%s];\n", e.from, e.to, e.label(), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// watchAwait logs the chain of containers blocking wc if it is not ready
+// before the world's await report threshold. It returns when done is closed.
+func (w *World) watchAwait(wc *WorldContainer, done <-chan struct{}) {
+	if w.opts.awaitReport <= 0 {
+		return
+	}
+	start := time.Now()
+	timer := time.NewTimer(w.opts.awaitReport)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		chain := wc.blockingChain(make(map[*replicaGroup]bool))
+		w.t.Logf("Await of %s blocked for %s:\n%s", wc.Name, time.Since(start).Round(time.Second), strings.Join(chain, "\n"))
+	}
+}
+
+// blockingChain describes what wc is waiting for, one line per container,
+// following unfinished dependencies recursively.
+func (wc *WorldContainer) blockingChain(seen map[*replicaGroup]bool) []string {
+	if seen[wc.group] {
+		return nil
+	}
+	seen[wc.group] = true
+
+	var lines []string
+	indent := func(sub []string) {
+		for _, l := range sub {
+			lines = append(lines, "  "+l)
+		}
+	}
+	for _, dep := range wc.after {
+		if !dep.isReady() {
+			lines = append(lines, fmt.Sprintf("%s is after %s", wc.Name, dep.Name))
+			indent(dep.blockingChain(seen))
+		}
+	}
+	for _, pc := range wc.replicas() {
+		select {
+		case <-pc.ready:
+			continue
+		default:
+		}
+		pc.mu.Lock()
+		blockedOn := pc.blockedOn
+		pc.mu.Unlock()
+		if blockedOn == nil {
+			lines = append(lines, fmt.Sprintf("%s is being created", pc.name))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s requires %s", pc.name, blockedOn.Name))
+		indent(blockedOn.blockingChain(seen))
+	}
+	return lines
+}
+
+// isReady reports whether every replica has finished creation, without
+// blocking.
+func (wc *WorldContainer) isReady() bool {
+	for _, pc := range wc.replicas() {
+		select {
+		case <-pc.ready:
+		default:
+			return false
+		}
+	}
+	return true
+}
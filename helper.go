@@ -0,0 +1,35 @@
+package testworld
+
+import (
+	"context"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// helperImage provides iptables and tcpdump for helper containers. It is
+// pinned, so the firewall and capture tooling do not change under tests.
+const helperImage = "nicolaka/netshoot:v0.13"
+
+// startHelper starts a helper container from helperImage. Any stale container
+// with the same name left over from a previous run is removed first.
+func (w *World) startHelper(ctx context.Context, req testcontainers.ContainerRequest) (testcontainers.Container, error) {
+	//nolint:errcheck
+	w.docker.ContainerRemove(ctx, req.Name, client.ContainerRemoveOptions{Force: true})
+
+	req.Image = helperImage
+	return testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		Started:          true,
+		ContainerRequest: req,
+	})
+}
+
+// helperNetworkMode returns a host config modifier that runs a helper in the
+// given network mode with the capabilities needed by iptables and tcpdump.
+func helperNetworkMode(mode string) func(*container.HostConfig) {
+	return func(hc *container.HostConfig) {
+		hc.NetworkMode = container.NetworkMode(mode)
+		hc.CapAdd = append(hc.CapAdd, "NET_ADMIN", "NET_RAW")
+	}
+}
@@ -0,0 +1,65 @@
+package testworld
+
+import (
+	"fmt"
+
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// RunJob creates a container that runs to completion, such as a migration,
+// seeder or CLI tool, and waits until every replica has exited. An exit code
+// other than spec.ExitCode fails the test here. The job output is written to
+// the world log when it exits.
+func (w *World) RunJob(spec ContainerSpec) WorldContainer {
+	job := w.StartJob(spec)
+	job.Await()
+	return job
+}
+
+// StartJob is like RunJob but returns immediately, like NewContainer. The
+// job's replicas count as ready once they have exited with spec.ExitCode, and
+// any other exit code fails the test when the job is awaited. Containers
+// listing the job in Requires or After therefore start only after it
+// succeeded.
+func (w *World) StartJob(spec ContainerSpec) WorldContainer {
+	spec.KeepAlive = false
+	return w.newContainer(spec, true)
+}
+
+// runJob waits for a job container to exit, logs its output and checks the
+// exit code.
+func (w *World) runJob(pc *pendingContainer, container testcontainers.Container, expectCode int) (err error) {
+	event := pc.event.child("job", "%s: job", pc.name)
+	defer event.finish()
+	defer func() {
+		if err != nil {
+			event.fail("%v", err)
+		}
+	}()
+
+	res := w.docker.ContainerWait(w.ctx, container.GetContainerID(), client.ContainerWaitOptions{})
+	var exitCode int64
+	select {
+	case r := <-res.Result:
+		if r.Error != nil {
+			return fmt.Errorf("wait for job: %s", r.Error.Message)
+		}
+		exitCode = r.StatusCode
+	case err := <-res.Error:
+		return fmt.Errorf("wait for job: %w", err)
+	}
+
+	if err := w.copyLogs(event, pc.name, container.GetContainerID()); err != nil {
+		return fmt.Errorf("failed to get job logs: %w", err)
+	}
+	if event != nil {
+		fmt.Fprintf(event.log, "Job exited with code %d\n", exitCode)
+	}
+
+	event.setExitCode(int(exitCode))
+	if exitCode != int64(expectCode) {
+		return fmt.Errorf("job exited with code %d (expected %d)", exitCode, expectCode)
+	}
+	return nil
+}
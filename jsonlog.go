@@ -0,0 +1,151 @@
+package testworld
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// jsonWorldLog is the machine-readable world log, written next to the text
+// log as log_<world>.json.
+type jsonWorldLog struct {
+	World        string           `json:"world"`
+	Failed       bool             `json:"failed"`
+	Start        time.Time        `json:"start"`
+	Finish       time.Time        `json:"finish"`
+	Duration     float64          `json:"duration_seconds"`
+	CombinedLog  string           `json:"combined_log"`
+	Containers   []jsonContainer  `json:"containers"`
+	Dependencies []jsonDependency `json:"dependencies"`
+	Events       []jsonEvent      `json:"events"`
+}
+
+// jsonContainer is a container group in the inventory.
+type jsonContainer struct {
+	Name     string        `json:"name"`
+	Image    string        `json:"image"`
+	Isolated bool          `json:"isolated,omitempty"`
+	Egress   []string      `json:"egress_allow,omitempty"`
+	Address  string        `json:"ipv4_address,omitempty"`
+	Replicas []jsonReplica `json:"replicas"`
+}
+
+// jsonReplica is a single replica of a container group.
+type jsonReplica struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// jsonDependency is a Requires or After edge between container groups.
+type jsonDependency struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Kind      string  `json:"kind"`
+	Condition string  `json:"condition,omitempty"`
+	Waited    float64 `json:"waited_seconds"`
+}
+
+// jsonEvent is an event of the timeline. Finish is omitted for events that
+// never finished.
+type jsonEvent struct {
+	ID          int64        `json:"id"`
+	Parent      *int64       `json:"parent,omitempty"`
+	Deps        []int64      `json:"deps,omitempty"`
+	Kind        string       `json:"kind,omitempty"`
+	Description string       `json:"description"`
+	Container   string       `json:"container,omitempty"`
+	Replica     string       `json:"replica,omitempty"`
+	ExitCode    *int         `json:"exit_code,omitempty"`
+	Failure     string       `json:"failure,omitempty"`
+	Start       time.Time    `json:"start"`
+	Finish      *time.Time   `json:"finish,omitempty"`
+	Duration    float64      `json:"duration_seconds"`
+	Output      *eventOutput `json:"output,omitempty"`
+}
+
+// eventOutput locates an event's log within the combined text log.
+type eventOutput struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// writeJSON writes the JSON world log. outputs maps events to the location
+// of their log in the combined log.
+func (el *WorldLog) writeJSON(outputs map[*Event]eventOutput) error {
+	data, err := json.MarshalIndent(el.jsonDocument(outputs), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(el.artifactPath(el.world.name+".json"), append(data, '\n'), 0644)
+}
+
+// jsonDocument collects the contents of the JSON world log.
+func (el *WorldLog) jsonDocument(outputs map[*Event]eventOutput) jsonWorldLog {
+	doc := jsonWorldLog{
+		World:        el.world.name,
+		Failed:       el.world.t.Failed(),
+		Start:        el.startTime,
+		Finish:       el.finishTime,
+		Duration:     el.finishTime.Sub(el.startTime).Seconds(),
+		CombinedLog:  el.combinedLogPath,
+		Containers:   []jsonContainer{},
+		Dependencies: []jsonDependency{},
+		Events:       []jsonEvent{},
+	}
+
+	for _, wc := range el.world.groups() {
+		c := jsonContainer{
+			Name:     wc.Name,
+			Image:    wc.group.template().image,
+			Isolated: wc.isolated,
+			Egress:   wc.egress,
+			Address:  wc.address,
+			Replicas: []jsonReplica{},
+		}
+		for _, pc := range wc.replicas() {
+			c.Replicas = append(c.Replicas, jsonReplica{Name: pc.name, Aliases: pc.aliases})
+		}
+		doc.Containers = append(doc.Containers, c)
+	}
+
+	for _, e := range el.world.dependencies() {
+		doc.Dependencies = append(doc.Dependencies, jsonDependency{
+			From:      e.from,
+			To:        e.to,
+			Kind:      e.kind,
+			Condition: e.condition,
+			Waited:    e.waited.Seconds(),
+		})
+	}
+
+	el.rw.RLock()
+	for _, e := range el.events {
+		je := jsonEvent{
+			ID:          e.id,
+			Kind:        e.kind,
+			Description: e.description,
+			Container:   e.container,
+			Replica:     e.replica,
+			ExitCode:    e.exitCode,
+			Failure:     e.failure,
+			Start:       e.startTime,
+			Duration:    eventDuration(e, el.finishTime).Seconds(),
+		}
+		if e.parent != nil {
+			je.Parent = &e.parent.id
+		}
+		for _, d := range e.deps {
+			je.Deps = append(je.Deps, d.id)
+		}
+		if !e.finishTime.IsZero() {
+			je.Finish = &e.finishTime
+		}
+		if out, ok := outputs[e]; ok {
+			je.Output = &out
+		}
+		doc.Events = append(doc.Events, je)
+	}
+	el.rw.RUnlock()
+	return doc
+}
@@ -0,0 +1,148 @@
+package testworld
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// junitKinds are the event kinds reported as JUnit test cases.
+var junitKinds = []string{"exec", "wait", "job"}
+
+// junitMu serializes updates of shared JUnit files by worlds of the same
+// test binary.
+var junitMu sync.Mutex
+
+// junitTestSuites is the root element of a JUnit XML file.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite holds the test cases of one world.
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single Exec, Wait or job run.
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure describes why a test case failed.
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitSuite converts the world's events into a test suite. Test cases are
+// grouped by the steps they ran in through their classname, e.g.
+// "TestApp/migrate". Failed test cases carry their own log followed by the
+// container logs of their replica as system-out.
+func (el *WorldLog) junitSuite() junitTestSuite {
+	el.rw.RLock()
+	defer el.rw.RUnlock()
+
+	suite := junitTestSuite{
+		Name:      el.world.name,
+		Time:      formatSeconds(el.finishTime.Sub(el.startTime)),
+		Timestamp: el.startTime.Format("2006-01-02T15:04:05"),
+	}
+	for _, e := range eventTree(el.events) {
+		if !slices.Contains(junitKinds, e.kind) {
+			continue
+		}
+		classname := []string{el.world.name}
+		for p := e.parent; p != nil; p = p.parent {
+			if p.kind == "step" {
+				classname = slices.Insert(classname, 1, strings.TrimPrefix(p.description, "Step: "))
+			}
+		}
+		tc := junitTestCase{
+			Name:      e.description,
+			Classname: strings.Join(classname, "/"),
+			Time:      formatSeconds(eventDuration(e, el.finishTime)),
+		}
+		if e.failure != "" {
+			tc.Failure = &junitFailure{Message: e.failure, Type: e.kind, Text: e.failure}
+			tc.SystemOut = el.failureOutput(e)
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Tests = len(suite.Cases)
+	return suite
+}
+
+// failureOutput returns the log of a failed event followed by the logs
+// collected from its replica. The caller must hold el.rw.
+func (el *WorldLog) failureOutput(failed *Event) string {
+	var b strings.Builder
+	for _, e := range el.events {
+		if e != failed && (e.kind != "logs" || e.replica == "" || e.replica != failed.replica) {
+			continue
+		}
+		if data, err := el.readEventLog(e); err == nil {
+			b.Write(data)
+		}
+	}
+	return b.String()
+}
+
+// formatSeconds formats a duration in seconds with millisecond precision.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// writeJUnit writes the world as a JUnit test suite. With an empty path the
+// suite is written to log_<world>.junit.xml next to the world log; otherwise
+// it is added to the shared file at path, replacing an earlier suite of the
+// same world.
+func (el *WorldLog) writeJUnit(path string) error {
+	suite := el.junitSuite()
+	if path == "" {
+		return writeJUnitFile(el.artifactPath(el.world.name+".junit.xml"), junitTestSuites{Suites: []junitTestSuite{suite}})
+	}
+
+	junitMu.Lock()
+	defer junitMu.Unlock()
+	var doc junitTestSuites
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+	}
+	doc.Suites = slices.DeleteFunc(doc.Suites, func(s junitTestSuite) bool { return s.Name == suite.Name })
+	doc.Suites = append(doc.Suites, suite)
+	return writeJUnitFile(path, doc)
+}
+
+// writeJUnitFile writes a JUnit XML document.
+func writeJUnitFile(path string, doc junitTestSuites) error {
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
@@ -0,0 +1,144 @@
+package testworld
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/client"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+// logLineLayout is the timestamp layout of collected container output. It
+// has a fixed width so lines sort and align by time.
+const logLineLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// taggedWriter returns a writer that writes each line to w as
+// "<time> [<replica> <stream>] <text>". If docker is set, lines are expected
+// to start with the RFC 3339 timestamp Docker adds to logs, which is used
+// instead of the time the line was written.
+func taggedWriter(w io.Writer, replica, stream string, docker bool) *lineWriter {
+	return &lineWriter{emit: func(line []byte) {
+		at := time.Now()
+		if docker {
+			if i := bytes.IndexByte(line, ' '); i > 0 {
+				if t, err := time.Parse(time.RFC3339Nano, string(line[:i])); err == nil {
+					at = t
+					line = line[i+1:]
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s [%s %s] %s", at.UTC().Format(logLineLayout), replica, stream, line)
+	}}
+}
+
+// copyLogs writes the output of the container with the given ID to the
+// event log, tagged with the replica name and stream and stamped with the
+// time Docker received each line.
+func (w *World) copyLogs(event *Event, replica, id string) error {
+	reader, err := w.docker.ContainerLogs(w.ctx, id, client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var to io.Writer = io.Discard
+	if event != nil {
+		to = event.log
+	}
+	stdout := taggedWriter(to, replica, "stdout", true)
+	stderr := taggedWriter(to, replica, "stderr", true)
+	_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	stdout.flush()
+	stderr.flush()
+	return err
+}
+
+// taggedExecOutput returns an exec option that copies the command output to
+// w as it arrives, tagged like taggedWriter. Docker does not timestamp exec
+// output, so lines are stamped when they are received. The reader returned
+// by Exec reaches EOF once all output is copied.
+func taggedExecOutput(w io.Writer, replica string) tcexec.ProcessOption {
+	return tcexec.ProcessOptionFunc(func(opts *tcexec.ProcessOptions) {
+		// The option is applied before and after the exec is attached;
+		// only the second time is there output to read.
+		if opts.Reader == nil {
+			return
+		}
+		src := opts.Reader
+		pr, pw := io.Pipe()
+		go func() {
+			stdout := taggedWriter(w, replica, "stdout", false)
+			stderr := taggedWriter(w, replica, "stderr", false)
+			_, err := stdcopy.StdCopy(stdout, stderr, src)
+			stdout.flush()
+			stderr.flush()
+			pw.CloseWithError(err)
+		}()
+		opts.Reader = pr
+	})
+}
+
+// outputLine is a line of tagged container output and the time it carries.
+type outputLine struct {
+	at   time.Time
+	text string
+}
+
+// parseOutputLine parses a line written by taggedWriter.
+func parseOutputLine(line string) (outputLine, bool) {
+	stamp, rest, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(rest, "[") {
+		return outputLine{}, false
+	}
+	at, err := time.Parse(logLineLayout, stamp)
+	if err != nil {
+		return outputLine{}, false
+	}
+	return outputLine{at: at, text: line}, true
+}
+
+// printMergedOutput writes the output of all replicas, collected by logs,
+// jobs and execs, as one list sorted by time, so what containers did at the
+// same moment can be read side by side.
+func (el *WorldLog) printMergedOutput() {
+	var lines []outputLine
+	for _, e := range eventTree(el.events) {
+		if e.kind != "logs" && e.kind != "job" && e.kind != "exec" {
+			continue
+		}
+		f, err := os.Open(el.eventLogPath(e.id))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			if l, ok := parseOutputLine(scanner.Text()); ok {
+				lines = append(lines, l)
+			}
+		}
+		f.Close()
+	}
+	if len(lines) == 0 {
+		return
+	}
+	slices.SortStableFunc(lines, func(a, b outputLine) int {
+		return a.at.Compare(b.at)
+	})
+
+	fmt.Fprintln(el.combinedLog, "\n\nMerged Container Output:")
+	for _, l := range lines {
+		fmt.Fprintln(el.combinedLog, l.text)
+	}
+}
@@ -0,0 +1,189 @@
+package testworld
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Option configures optional World behaviour. Options are passed to New.
+type Option func(*worldOptions)
+
+// worldOptions holds the settings collected from Option values. New keeps
+// them in World.opts once defaults are applied.
+type worldOptions struct {
+	externalSubnet   string
+	internalSubnet   string
+	dns              DNSPolicy
+	awaitReport      time.Duration // log the blocking chain of Awaits exceeding this
+	conditionTimeout time.Duration // fail dependency conditions not met within this
+	htmlReport       bool          // write an HTML report next to the world log
+	junit            bool          // write a JUnit XML test suite
+	junitPath        string        // shared JUnit file, or "" for one per world
+	tracing          bool
+	spanExporters    []sdktrace.SpanExporter
+	logRetention     LogRetention // when the full world log is kept
+	logSummary       bool         // keep the summary if the full log is not kept
+	failureTail      int          // lines of output logged on failure, 0 disables
+	secrets          []string
+	secretPatterns   []*regexp.Regexp
+	statsInterval    time.Duration // resource usage sampling interval, 0 disables
+}
+
+// WithExternalSubnet sets the IPv4 subnet (e.g. "10.200.0.0/24") of the
+// external network. Worlds with the same subnet configuration share networks.
+func WithExternalSubnet(cidr string) Option {
+	return func(o *worldOptions) { o.externalSubnet = cidr }
+}
+
+// WithInternalSubnet sets the IPv4 subnet (e.g. "10.201.0.0/24") of the
+// internal network. It is required for ContainerSpec.IPv4Address.
+func WithInternalSubnet(cidr string) Option {
+	return func(o *worldOptions) { o.internalSubnet = cidr }
+}
+
+// WithDNS enables the world DNS resolver. Every container resolves names
+// through it: world aliases are answered from the world's containers, other
+// names are forwarded to the host's resolver or refused according to policy,
+// and every query is logged as an event and returned by World.DNSQueries.
+func WithDNS(policy DNSPolicy) Option {
+	return func(o *worldOptions) { o.dns = policy }
+}
+
+// WithAwaitReport sets how long an Await may block before the chain of
+// containers and dependencies blocking it is logged. The default is 30
+// seconds; a negative duration disables the report.
+func WithAwaitReport(after time.Duration) Option {
+	return func(o *worldOptions) { o.awaitReport = after }
+}
+
+// WithConditionTimeout sets how long each replica of a dependency may take
+// to meet the condition dependants wait for, such as Healthy or
+// CompletedSuccessfully. Dependants of a replica that does not meet it in
+// time fail to create, naming the chain of dependencies that blocked them.
+// The default is 5 minutes; a negative duration waits forever.
+func WithConditionTimeout(timeout time.Duration) Option {
+	return func(o *worldOptions) { o.conditionTimeout = timeout }
+}
+
+// WithHTMLReport makes the world log also write log_<world>.html, a
+// self-contained report with an interactive timeline, the container
+// inventory and a searchable viewer for the event logs.
+func WithHTMLReport() Option {
+	return func(o *worldOptions) { o.htmlReport = true }
+}
+
+// WithJUnit makes the world log also write a JUnit XML test suite for the
+// world, with a test case for every Exec, Wait and job run. Failed test cases
+// carry the failure and the logs of their replica. With an empty path the
+// suite is written to log_<world>.junit.xml; otherwise it is added to the
+// shared file at path, so all worlds of a test binary can report to one file.
+func WithJUnit(path string) Option {
+	return func(o *worldOptions) {
+		o.junit = true
+		o.junitPath = path
+	}
+}
+
+// WithTracing exports the world's events as OpenTelemetry spans below a root
+// span named after the test. Spans are sent to the given exporters, e.g. an
+// OTLP exporter for a local collector, or written as OTLP JSON to
+// log_<world>.otlp.jsonl next to the world log if there are none. Containers
+// get the trace context of their creation span in the TRACEPARENT
+// environment variable, so the spans of traced services join the trace.
+// The default file exporter requires a world log path; explicit exporters
+// work without one.
+func WithTracing(exporters ...sdktrace.SpanExporter) Option {
+	return func(o *worldOptions) {
+		o.tracing = true
+		o.spanExporters = exporters
+	}
+}
+
+// WithLogRetention sets when the full world log of a test is kept. When it is
+// not, every file the world wrote next to the log is removed, and the
+// combined log keeps only the summary (inventory, timeline and critical
+// path) if summary is set, or is removed too. Spans and JUnit results are
+// written either way.
+func WithLogRetention(policy LogRetention, summary bool) Option {
+	return func(o *worldOptions) {
+		o.logRetention = policy
+		o.logSummary = summary
+	}
+}
+
+// WithFailureLogs makes Destroy print the output of every replica and of
+// every failed Exec, Wait or job through t.Log if the test has failed,
+// grouped per replica, so failures can be diagnosed without the world log.
+// Only the last tail lines of each output are printed; zero or less prints
+// the last 50. It works without a world log path, as in CI runs that keep
+// no log files.
+func WithFailureLogs(tail int) Option {
+	return func(o *worldOptions) {
+		o.failureTail = defaultFailureTail
+		if tail > 0 {
+			o.failureTail = tail
+		}
+	}
+}
+
+// WithSecrets masks the given values, such as passwords and tokens, in the
+// world log and every report, like ContainerSpec.Secrets but for the whole
+// world.
+func WithSecrets(secrets ...string) Option {
+	return func(o *worldOptions) { o.secrets = append(o.secrets, secrets...) }
+}
+
+// WithSecretPatterns is like WithSecrets, but masks every match of the
+// patterns, e.g. regexp.MustCompile(`Bearer \S+`).
+func WithSecretPatterns(patterns ...*regexp.Regexp) Option {
+	return func(o *worldOptions) { o.secretPatterns = append(o.secretPatterns, patterns...) }
+}
+
+// WithResourceStats samples the CPU, memory, network and block IO usage of
+// every replica from the time it starts until the world is destroyed. The
+// world log gets a table of peak and total usage per replica and the samples
+// as time series, and WorldContainer.ResourceStats returns them to the test.
+// Docker produces stats about once a second, so intervals below a second,
+// including zero or less, sample every second.
+func WithResourceStats(interval time.Duration) Option {
+	return func(o *worldOptions) { o.statsInterval = max(interval, defaultStatsInterval) }
+}
+
+// networkConfig parses and validates the subnet options. The zero value means
+// Docker chooses the subnets.
+func (o *worldOptions) networkConfig() (networkConfig, error) {
+	var cfg networkConfig
+	var err error
+	if cfg.external, err = parseSubnet("external", o.externalSubnet); err != nil {
+		return cfg, err
+	}
+	if cfg.internal, err = parseSubnet("internal", o.internalSubnet); err != nil {
+		return cfg, err
+	}
+	if cfg.external.IsValid() && cfg.internal.IsValid() && cfg.external.Overlaps(cfg.internal) {
+		return cfg, fmt.Errorf("external subnet %s overlaps internal subnet %s", cfg.external, cfg.internal)
+	}
+	return cfg, nil
+}
+
+// parseSubnet parses an IPv4 CIDR. An empty string yields the zero prefix.
+func parseSubnet(which, cidr string) (netip.Prefix, error) {
+	if cidr == "" {
+		return netip.Prefix{}, nil
+	}
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid %s subnet %q: %w", which, cidr, err)
+	}
+	if !p.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("invalid %s subnet %q: only IPv4 subnets are supported", which, cidr)
+	}
+	if p.Bits() > 29 {
+		return netip.Prefix{}, fmt.Errorf("invalid %s subnet %q: prefix must be /29 or larger", which, cidr)
+	}
+	return p.Masked(), nil
+}
@@ -0,0 +1,262 @@
+package testworld
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the world's spans.
+const tracerName = "github.com/AlveElde/testworld-go"
+
+// tracing exports the events of a world as OpenTelemetry spans, all below a
+// root span for the test.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	root     trace.Span
+	ctx      context.Context // carries the root span
+}
+
+// startTracing starts the root span of the world. Without exporters, spans
+// are written as OTLP JSON to log_<world>.otlp.jsonl next to the world log.
+func (el *WorldLog) startTracing(exporters []sdktrace.SpanExporter) error {
+	if el == nil || el.world == nil {
+		return nil
+	}
+	if len(exporters) == 0 {
+		if el.dir == "" {
+			return errors.New("tracing without exporters requires a world log path")
+		}
+		exporter, err := newOTLPFileExporter(el.artifactPath(el.world.name + ".otlp.jsonl"))
+		if err != nil {
+			return err
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "testworld"),
+			attribute.String("testworld.world", el.world.name),
+		)),
+	}
+	for _, e := range exporters {
+		opts = append(opts, sdktrace.WithBatcher(e))
+	}
+	tr := &tracing{provider: sdktrace.NewTracerProvider(opts...)}
+	tr.tracer = tr.provider.Tracer(tracerName)
+	tr.ctx, tr.root = tr.tracer.Start(context.Background(), el.world.t.Name(), trace.WithTimestamp(el.startTime))
+	el.tracing = tr
+	return nil
+}
+
+// startSpan starts the span of a new event below the span of its parent, or
+// below the root span.
+func (el *WorldLog) startSpan(event *Event) {
+	if el.tracing == nil {
+		return
+	}
+	ctx := el.tracing.ctx
+	if event.parent != nil && event.parent.span != nil {
+		ctx = trace.ContextWithSpan(ctx, event.parent.span)
+	}
+	_, event.span = el.tracing.tracer.Start(ctx, event.description,
+		trace.WithTimestamp(event.startTime),
+		trace.WithAttributes(
+			attribute.Int64("testworld.event.id", event.id),
+			attribute.String("testworld.event.kind", event.kind),
+		))
+}
+
+// endSpan ends the span of an event at the given time, recording the
+// container, replica, exit code, dependencies and failure of the event.
+func (event *Event) endSpan(at time.Time) {
+	if event.span == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("testworld.container", event.container),
+		attribute.String("testworld.replica", event.replica),
+	}
+	if event.exitCode != nil {
+		attrs = append(attrs, attribute.Int("testworld.exit_code", *event.exitCode))
+	}
+	event.el.rw.RLock()
+	if len(event.deps) > 0 {
+		ids := make([]int64, len(event.deps))
+		for i, d := range event.deps {
+			ids[i] = d.id
+		}
+		attrs = append(attrs, attribute.Int64Slice("testworld.event.deps", ids))
+	}
+	event.el.rw.RUnlock()
+	event.span.SetAttributes(attrs...)
+	if event.failure != "" {
+		event.span.SetStatus(codes.Error, event.failure)
+	}
+	event.span.End(trace.WithTimestamp(at))
+}
+
+// traceparent returns the W3C trace context of the event's span, or "" if
+// the world is not traced.
+func (event *Event) traceparent() string {
+	if event == nil || event.span == nil {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(trace.ContextWithSpan(context.Background(), event.span), carrier)
+	return carrier.Get("traceparent")
+}
+
+// stopTracing ends the spans of unfinished events and the root span, and
+// flushes all spans to the exporters.
+func (el *WorldLog) stopTracing() error {
+	if el.tracing == nil {
+		return nil
+	}
+	el.rw.RLock()
+	events := slices.Clone(el.events)
+	el.rw.RUnlock()
+	for _, e := range events {
+		if e.finishTime.IsZero() {
+			e.endSpan(el.finishTime)
+		}
+	}
+	if el.world.t.Failed() {
+		el.tracing.root.SetStatus(codes.Error, "test failed")
+	}
+	el.tracing.root.End(trace.WithTimestamp(el.finishTime))
+	return el.tracing.provider.Shutdown(context.Background())
+}
+
+// otlpFileExporter writes spans in the OTLP JSON file format, one
+// ExportTraceServiceRequest per line, as read by the OpenTelemetry
+// Collector's otlpjsonfile receiver.
+type otlpFileExporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// newOTLPFileExporter creates the file at path and returns an exporter
+// writing to it.
+func newOTLPFileExporter(path string) (*otlpFileExporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &otlpFileExporter{f: f}, nil
+}
+
+// ExportSpans implements sdktrace.SpanExporter. The spans of one tracer
+// provider share a resource and scope, which are taken from the first span.
+func (x *otlpFileExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	var out []map[string]any
+	for _, s := range spans {
+		span := map[string]any{
+			"traceId":           s.SpanContext().TraceID().String(),
+			"spanId":            s.SpanContext().SpanID().String(),
+			"name":              s.Name(),
+			"kind":              int(s.SpanKind()),
+			"startTimeUnixNano": strconv.FormatInt(s.StartTime().UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attributes()),
+			"status":            map[string]any{"code": otlpStatusCode(s.Status().Code), "message": s.Status().Description},
+		}
+		if s.Parent().IsValid() {
+			span["parentSpanId"] = s.Parent().SpanID().String()
+		}
+		out = append(out, span)
+	}
+	scope := spans[0].InstrumentationScope()
+	line, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(spans[0].Resource().Attributes())},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": scope.Name, "version": scope.Version},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	_, err = x.f.Write(append(line, '\n'))
+	return err
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (x *otlpFileExporter) Shutdown(context.Context) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.f.Close()
+}
+
+// otlpStatusCode maps a span status to its OTLP value: unset, ok or error.
+func otlpStatusCode(c codes.Code) int {
+	switch c {
+	case codes.Ok:
+		return 1
+	case codes.Error:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// otlpAttributes converts attributes to OTLP JSON key-value pairs.
+func otlpAttributes(attrs []attribute.KeyValue) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for _, kv := range attrs {
+		out = append(out, map[string]any{"key": string(kv.Key), "value": otlpValue(kv.Value)})
+	}
+	return out
+}
+
+// otlpValue converts an attribute value to an OTLP JSON AnyValue. 64-bit
+// integers are encoded as strings.
+func otlpValue(v attribute.Value) map[string]any {
+	array := func(values []map[string]any) map[string]any {
+		return map[string]any{"arrayValue": map[string]any{"values": values}}
+	}
+	switch v.Type() {
+	case attribute.BOOL:
+		return map[string]any{"boolValue": v.AsBool()}
+	case attribute.INT64:
+		return map[string]any{"intValue": strconv.FormatInt(v.AsInt64(), 10)}
+	case attribute.FLOAT64:
+		return map[string]any{"doubleValue": v.AsFloat64()}
+	case attribute.INT64SLICE:
+		var values []map[string]any
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, otlpValue(attribute.Int64Value(i)))
+		}
+		return array(values)
+	case attribute.STRINGSLICE:
+		var values []map[string]any
+		for _, s := range v.AsStringSlice() {
+			values = append(values, otlpValue(attribute.StringValue(s)))
+		}
+		return array(values)
+	default:
+		return map[string]any{"stringValue": v.Emit()}
+	}
+}
@@ -0,0 +1,83 @@
+package testworld
+
+import (
+	"cmp"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// redactedMask replaces every secret in the world log.
+const redactedMask = "[REDACTED]"
+
+// redactor masks secrets, given as literal values or patterns, in
+// everything the world writes to its log and reports. See WithSecrets and
+// ContainerSpec.Secrets.
+type redactor struct {
+	mu       sync.RWMutex
+	literals []string // longest first, so overlapping secrets are fully masked
+	patterns []*regexp.Regexp
+}
+
+// add registers secrets to mask from now on. Empty literals are ignored.
+func (r *redactor) add(literals []string, patterns []*regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range literals {
+		if s != "" && !slices.Contains(r.literals, s) {
+			r.literals = append(r.literals, s)
+		}
+	}
+	slices.SortStableFunc(r.literals, func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+	r.patterns = append(r.patterns, patterns...)
+}
+
+// redact returns s with every secret replaced by redactedMask.
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, lit := range r.literals {
+		s = strings.ReplaceAll(s, lit, redactedMask)
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, redactedMask)
+	}
+	return s
+}
+
+// writer returns a writer that masks secrets line by line before writing to
+// w, so a secret split across writes is still found. Secrets spanning lines
+// are not masked. Closing it writes a final unterminated line and closes w.
+func (r *redactor) writer(w io.WriteCloser) io.WriteCloser {
+	rw := &redactingWriter{w: w}
+	rw.lines.emit = func(line []byte) {
+		io.WriteString(w, r.redact(string(line)))
+	}
+	return rw
+}
+
+// redactingWriter is the writer returned by redactor.writer.
+type redactingWriter struct {
+	mu    sync.Mutex
+	w     io.WriteCloser
+	lines lineWriter
+}
+
+// Write implements io.Writer.
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.lines.Write(p)
+}
+
+// Close implements io.Closer.
+func (rw *redactingWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.lines.flush()
+	return rw.w.Close()
+}
@@ -0,0 +1,357 @@
+package testworld
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// replicaTemplate holds everything needed to create another replica of a
+// group, so Scale can add replicas long after NewContainer returned.
+type replicaTemplate struct {
+	spec               ContainerSpec
+	kind               string
+	name               string
+	image              string   // image name, or "dockerfile:<context>" for custom builds
+	fileContents       [][]byte // buffered io.Reader contents of spec.Files
+	contextArchiveData []byte   // buffered spec.FromDockerfile.ContextArchive
+	sharedNames        []string // names carrying the group's SRV records
+	ports              []servicePort
+	job                bool // replicas run to completion, see World.StartJob
+}
+
+// newReplicaTemplate prepares a spec for creating any number of replicas.
+func (w *World) newReplicaTemplate(spec ContainerSpec, kind, name string) *replicaTemplate {
+	tmpl := &replicaTemplate{
+		spec:  spec,
+		kind:  kind,
+		name:  name,
+		image: spec.Image,
+		// Names shared by every replica carry the group's SRV records.
+		sharedNames: append([]string{name}, spec.Aliases...),
+		ports:       servicePorts(spec.ExposedPorts, spec.ServiceNames),
+	}
+
+	// Derive a human-readable image label for the inventory log.
+	if tmpl.image == "" {
+		ctx := spec.FromDockerfile.Context
+		if ctx == "" {
+			ctx = "<archive>"
+		}
+		tmpl.image = "dockerfile:" + ctx
+	}
+
+	// Buffer any io.Reader-based file contents once before spawning replica
+	// goroutines. An io.Reader can only be consumed once, so each replica must
+	// get its own independent bytes.Reader over the same underlying bytes.
+	tmpl.fileContents = make([][]byte, len(spec.Files))
+	for i, f := range spec.Files {
+		if f.Reader != nil {
+			data, err := io.ReadAll(f.Reader)
+			if err != nil {
+				w.t.Fatalf("Failed to buffer file %q for container %s: %v", f.ContainerFilePath, name, err)
+			}
+			tmpl.fileContents[i] = data
+		}
+	}
+
+	// Buffer ContextArchive (io.ReadSeeker) for the same reason: the first
+	// replica goroutine to run would exhaust the reader, leaving every other
+	// replica with an empty archive.
+	if spec.FromDockerfile.ContextArchive != nil {
+		data, err := io.ReadAll(spec.FromDockerfile.ContextArchive)
+		if err != nil {
+			w.t.Fatalf("Failed to buffer ContextArchive for container %s: %v", name, err)
+		}
+		tmpl.contextArchiveData = data
+	}
+	return tmpl
+}
+
+// replicaGroup is the mutable state shared by all copies of a WorldContainer.
+type replicaGroup struct {
+	suffixed bool       // replica names carry a -N suffix even for replica 1
+	scaleMu  sync.Mutex // serializes Scale and RollingUpdate calls
+
+	// mu guards the fields below.
+	mu       sync.Mutex
+	tmpl     *replicaTemplate    // spec for new replicas, replaced by RollingUpdate
+	pending  []*pendingContainer // live replicas in replica number order
+	gen      int                 // incremented whenever replicas are added
+	readyGen int                 // gen of the last completed Await
+}
+
+// newReplicaGroup returns an empty group. Replicas are added with addReplica.
+func newReplicaGroup(tmpl *replicaTemplate, suffixed bool) *replicaGroup {
+	return &replicaGroup{tmpl: tmpl, suffixed: suffixed, readyGen: -1}
+}
+
+// template returns the spec new replicas are created from.
+func (g *replicaGroup) template() *replicaTemplate {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.tmpl
+}
+
+// freeIndex returns the lowest replica number no live replica has, so
+// replicas removed by Scale free their names for the next ones.
+func (g *replicaGroup) freeIndex() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for index := 1; ; index++ {
+		if !slices.ContainsFunc(g.pending, func(pc *pendingContainer) bool { return pc.index == index }) {
+			return index
+		}
+	}
+}
+
+// add appends a replica to the group and invalidates readiness.
+func (g *replicaGroup) add(pc *pendingContainer) {
+	g.mu.Lock()
+	g.pending = append(g.pending, pc)
+	g.gen++
+	g.mu.Unlock()
+}
+
+// readiness returns the current generation and whether Await has already
+// completed for it.
+func (g *replicaGroup) readiness() (int, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gen, g.readyGen == g.gen
+}
+
+// markReady records that every replica of generation gen is ready.
+func (g *replicaGroup) markReady(gen int) {
+	g.mu.Lock()
+	g.readyGen = gen
+	g.mu.Unlock()
+}
+
+// replicas returns a snapshot of the group's live replicas.
+func (wc *WorldContainer) replicas() []*pendingContainer {
+	wc.group.mu.Lock()
+	defer wc.group.mu.Unlock()
+	return slices.Clone(wc.group.pending)
+}
+
+// Scale changes the number of replicas in the group to n. New replicas get
+// the lowest free -N names and are created in the background like those from
+// NewContainer; call Await to wait for them. When scaling down, the
+// highest-numbered replicas are removed once operations already running on
+// them finish, and their logs are collected first. Scale is safe to call
+// while other goroutines use the group.
+func (wc *WorldContainer) Scale(n int) {
+	w := wc.world
+	if n < 0 {
+		w.t.Fatalf("Cannot scale %s to %d replicas", wc.Name, n)
+	}
+	if n > 1 && wc.address != "" {
+		w.t.Fatalf("Cannot scale %s to %d replicas: it has a pinned IPv4Address", wc.Name, n)
+	}
+
+	g := wc.group
+	g.scaleMu.Lock()
+	defer g.scaleMu.Unlock()
+
+	current := wc.replicas()
+	if n == len(current) {
+		return
+	}
+	step := w.worldLog.currentStep()
+	event := w.worldLog.newStepEvent(step, "", "%s: scale %d -> %d", wc.Name, len(current), n)
+	defer event.finish()
+	event.setContainer(wc.Name)
+
+	for range n - len(current) {
+		if err := w.addReplica(g, step); err != nil {
+			w.t.Fatalf("Failed to add replica to %s: %v", wc.Name, err)
+		}
+	}
+	if n > len(current) {
+		return
+	}
+
+	// Detach the removed replicas first so new operations no longer see them.
+	g.mu.Lock()
+	removed := slices.Clone(g.pending[n:])
+	g.pending = g.pending[:n:n]
+	g.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, pc := range removed {
+		wg.Add(1)
+		go func(pc *pendingContainer) {
+			defer wg.Done()
+			wc.removeReplica(pc, step)
+		}(pc)
+	}
+	wg.Wait()
+}
+
+// removeReplica waits for in-flight operations on a replica, collects its
+// logs and captures, and force-removes it together with its helpers. Its
+// events are part of step if it is not nil.
+func (wc *WorldContainer) removeReplica(pc *pendingContainer, step *Event) {
+	w := wc.world
+	<-pc.ready
+
+	pc.inUse.Lock()
+	pc.removed = true
+	pc.inUse.Unlock()
+
+	event := w.worldLog.newStepEvent(step, "", "World: remove container %s", pc.name)
+	defer event.finish()
+	event.setReplica(pc)
+
+	if w.dns != nil {
+		w.dns.unregister(pc)
+	}
+
+	var ids []string
+	if pc.err == nil {
+		if err := wc.logOneInternal(pc, step); err != nil {
+			w.t.Log("Failed to collect logs for container ", pc.name, ": ", err)
+		}
+		ids = append(ids, pc.container.GetContainerID())
+	}
+	pc.stopFollowing()
+	pc.stopSampling()
+	for _, c := range w.takeCaptures(pc) {
+		if err := w.saveCapture(c); err != nil {
+			w.t.Log("Failed to save capture ", c.label, ": ", err)
+		}
+	}
+	pc.mu.Lock()
+	for _, sc := range pc.sidecars {
+		ids = append(ids, sc.GetContainerID())
+	}
+	pc.mu.Unlock()
+
+	for _, id := range ids {
+		//nolint:errcheck
+		w.docker.ContainerRemove(w.ctx, id, client.ContainerRemoveOptions{
+			RemoveVolumes: true,
+			Force:         true,
+		})
+	}
+}
+
+// RollingOpts configures WorldContainer.RollingUpdate.
+type RollingOpts struct {
+	// MaxUnavailable is the number of replicas replaced at the same time.
+	// Defaults to 1.
+	MaxUnavailable int
+
+	// WaitingFor is checked on each new replica before the next one is
+	// replaced, in addition to the new spec's own WaitingFor.
+	WaitingFor wait.Strategy
+}
+
+// RollingUpdate replaces the replicas of the group with containers created
+// from spec, MaxUnavailable replicas at a time in replica number order. Each
+// replacement keeps the replica's name, aliases and TLS certificate, and the
+// next batch starts only once the new replicas are ready. The group's
+// identity and networking (Replicas, Aliases, Subdomains, Isolated,
+// EgressAllow, IPv4Address, Requires and After) are kept from the original
+// spec. Replicas added by Scale afterwards use the new spec. The update stops
+// and fails the test at the first batch with a failed replica.
+func (wc *WorldContainer) RollingUpdate(spec ContainerSpec, opts RollingOpts) {
+	w := wc.world
+	g := wc.group
+	g.scaleMu.Lock()
+	defer g.scaleMu.Unlock()
+
+	old := g.template()
+	spec.Replicas = old.spec.Replicas
+	spec.Aliases = old.spec.Aliases
+	spec.Subdomains = old.spec.Subdomains
+	spec.Isolated = old.spec.Isolated
+	spec.EgressAllow = old.spec.EgressAllow
+	spec.IPv4Address = old.spec.IPv4Address
+	spec.Requires = old.spec.Requires
+	spec.After = old.spec.After
+	tmpl := w.newReplicaTemplate(spec, old.kind, old.name)
+	tmpl.job = old.job
+
+	step := w.worldLog.currentStep()
+	event := w.worldLog.newStepEvent(step, "", "%s: rolling update to %s", wc.Name, tmpl.image)
+	defer event.finish()
+	event.setContainer(wc.Name)
+
+	g.mu.Lock()
+	g.tmpl = tmpl
+	g.mu.Unlock()
+
+	batch := max(opts.MaxUnavailable, 1)
+	replicas := wc.replicas()
+	for start := 0; start < len(replicas); start += batch {
+		var wg sync.WaitGroup
+		var failed atomic.Bool
+		for _, pc := range replicas[start:min(start+batch, len(replicas))] {
+			wg.Add(1)
+			go func(pc *pendingContainer) {
+				defer wg.Done()
+				if err := wc.replaceReplica(tmpl, pc, opts.WaitingFor, step); err != nil {
+					w.t.Errorf("Rolling update of %s failed: %v", pc.name, err)
+					failed.Store(true)
+				}
+			}(pc)
+		}
+		wg.Wait()
+		if failed.Load() {
+			w.t.FailNow()
+		}
+	}
+}
+
+// replaceReplica removes a replica and recreates it from tmpl under the same
+// name, aliases and certificate, waiting until the new container is ready.
+// Its events are part of step if it is not nil.
+func (wc *WorldContainer) replaceReplica(tmpl *replicaTemplate, old *pendingContainer, waitingFor wait.Strategy, step *Event) error {
+	w := wc.world
+	event := w.worldLog.newStepEvent(step, "", "%s: replace", old.name)
+	defer event.finish()
+	event.setReplica(old)
+
+	pc := &pendingContainer{
+		name:    old.name,
+		group:   old.group,
+		index:   old.index,
+		aliases: old.aliases,
+		certPEM: old.certPEM,
+		keyPEM:  old.keyPEM,
+		ready:   make(chan struct{}),
+	}
+	containerRequest, err := w.replicaRequest(tmpl, pc)
+	if err != nil {
+		return err
+	}
+
+	// Swap the replica in first, so new operations wait for the new
+	// container while the old one is removed.
+	g := wc.group
+	g.mu.Lock()
+	if i := slices.Index(g.pending, old); i >= 0 {
+		g.pending[i] = pc
+	}
+	g.gen++
+	g.mu.Unlock()
+
+	wc.removeReplica(old, step)
+	w.createReplica(tmpl, pc, containerRequest, step)
+	if pc.err != nil {
+		return pc.err
+	}
+	if waitingFor != nil {
+		if err := waitingFor.WaitUntilReady(w.ctx, pc.container); err != nil {
+			return fmt.Errorf("wait: %w", err)
+		}
+	}
+	return nil
+}
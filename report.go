@@ -0,0 +1,268 @@
+package testworld
+
+import (
+	"html/template"
+	"os"
+)
+
+// reportData is rendered into the HTML report. The JSON world log is
+// embedded as is, together with the events in timeline order and their logs.
+type reportData struct {
+	Log     jsonWorldLog `json:"log"`
+	Rows    []reportRow  `json:"rows"`
+	IDWidth int          `json:"id_width"` // digits event IDs are padded to, as in the world log
+}
+
+// reportRow is an event in timeline order.
+type reportRow struct {
+	ID    int64  `json:"id"`
+	Depth int    `json:"depth"`
+	Log   string `json:"log"`
+}
+
+// writeHTMLReport writes a single HTML file with an interactive timeline,
+// the container inventory and the event logs. All styles and scripts are
+// inline, so the file can be opened anywhere or attached to a CI run.
+func (el *WorldLog) writeHTMLReport(outputs map[*Event]eventOutput) error {
+	data := reportData{Log: el.jsonDocument(outputs), Rows: []reportRow{}, IDWidth: el.idWidth()}
+	el.rw.RLock()
+	for _, e := range eventTree(el.events) {
+		row := reportRow{ID: e.id, Depth: e.depth()}
+		if b, err := el.readEventLog(e); err == nil {
+			row.Log = string(b)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	el.rw.RUnlock()
+
+	f, err := os.Create(el.artifactPath(el.world.name + ".html"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := reportTemplate.Execute(f, data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// reportTemplate renders reportData. html/template escapes the data for
+// its context, so it is safe to embed arbitrary log output in the script.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>World {{.Log.World}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 1.5em; color: #222; }
+h1 .status { font-size: 0.6em; padding: 0.2em 0.6em; border-radius: 0.3em; color: #fff; vertical-align: middle; }
+.passed { background: #2e7d32; }
+.failed { background: #c62828; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+#timeline { overflow-x: auto; border: 1px solid #ddd; }
+#timeline .row { display: flex; align-items: center; height: 1.4em; font-size: 0.85em; }
+#timeline .row:hover { background: #f3f3f3; }
+#timeline .label { flex: 0 0 28em; white-space: pre; overflow: hidden; text-overflow: ellipsis; cursor: pointer; }
+#timeline .lane { position: relative; flex: 1 0 auto; height: 1em; }
+#timeline .bar { position: absolute; height: 100%; min-width: 2px; background: #1976d2; border-radius: 2px; cursor: pointer; }
+#timeline .bar.step { background: #9e9e9e; }
+#timeline .bar.unfinished { background: #ef6c00; }
+#timeline .bar.error { background: #c62828; }
+#controls { margin: 0.5em 0; }
+details { border-bottom: 1px solid #eee; padding: 0.2em 0; }
+details.highlight { background: #fff8e1; }
+summary { cursor: pointer; font-family: monospace; white-space: pre; }
+pre { background: #f7f7f7; padding: 0.5em; overflow-x: auto; max-height: 40em; }
+mark { background: #ffeb3b; }
+</style>
+</head>
+<body>
+<h1>World <span id="world"></span> <span id="status" class="status"></span></h1>
+<p id="summary"></p>
+
+<h2>Timeline</h2>
+<div id="controls">
+<label>Zoom <input id="zoom" type="range" min="1" max="20" value="1"></label>
+</div>
+<div id="timeline"></div>
+
+<h2>Container Inventory</h2>
+<table id="inventory">
+<tr><th>Container</th><th>Image</th><th>Network</th><th>Replicas</th></tr>
+</table>
+
+<h2 id="deps-title">Dependencies</h2>
+<table id="deps">
+<tr><th>Dependant</th><th>Dependency</th><th>Kind</th><th>Waited</th></tr>
+</table>
+
+<h2>Event Logs</h2>
+<div id="controls-logs">
+<input id="search" type="search" placeholder="Search events and logs" size="40">
+<button id="expand">Expand all</button>
+<button id="collapse">Collapse all</button>
+<span id="matches"></span>
+</div>
+<div id="logs"></div>
+
+<script>
+const data = {{.}};
+const doc = data.log;
+const byId = new Map(doc.events.map(e => [e.id, e]));
+const start = Date.parse(doc.start);
+const total = Math.max(Date.parse(doc.finish) - start, 1);
+
+function el(tag, props, ...children) {
+	const n = document.createElement(tag);
+	Object.assign(n, props || {});
+	for (const c of children) {
+		n.append(c);
+	}
+	return n;
+}
+
+function seconds(s) {
+	return s.toFixed(3) + "s";
+}
+
+document.getElementById("world").textContent = doc.world;
+const status = document.getElementById("status");
+status.textContent = doc.failed ? "FAILED" : "PASSED";
+status.classList.add(doc.failed ? "failed" : "passed");
+document.getElementById("summary").textContent =
+	"Started " + new Date(start).toLocaleString() + ", ran " + seconds(doc.duration_seconds) +
+	", " + doc.events.length + " events. Text log: " + doc.combined_log;
+
+// Timeline: one row per event, indented by depth, bars positioned relative
+// to the world's lifetime.
+const timeline = document.getElementById("timeline");
+const lanes = [];
+for (const row of data.rows) {
+	const e = byId.get(row.id);
+	const offset = (Date.parse(e.start) - start) / total * 100;
+	const width = e.duration_seconds * 1000 / total * 100;
+	const bar = el("div", {className: "bar", title: e.description + " (" + seconds(e.duration_seconds) + ")" +
+		(e.failure ? "\n" + e.failure : "")});
+	bar.style.left = offset + "%";
+	bar.style.width = width + "%";
+	if (e.kind === "step") {
+		bar.classList.add("step");
+	}
+	if (!e.finish) {
+		bar.classList.add("unfinished");
+	}
+	if (e.failure) {
+		bar.classList.add("error");
+	}
+	const label = el("div", {className: "label", title: e.description},
+		String(e.id).padStart(data.id_width, "0") + " " + "  ".repeat(row.depth) + e.description);
+	const lane = el("div", {className: "lane"}, bar);
+	lanes.push(lane);
+	const r = el("div", {className: "row"}, label, lane);
+	label.onclick = bar.onclick = () => showLog(e.id);
+	timeline.append(r);
+}
+document.getElementById("zoom").oninput = ev => {
+	for (const lane of lanes) {
+		lane.style.minWidth = (ev.target.value * 40) + "em";
+	}
+};
+
+// Inventory.
+const inventory = document.getElementById("inventory");
+for (const c of doc.containers) {
+	let network = c.isolated ? "isolated" : "";
+	if (c.egress_allow) {
+		network = "egress: " + c.egress_allow.join(", ");
+	}
+	if (c.ipv4_address) {
+		network = (c.ipv4_address + " " + network).trim();
+	}
+	const replicas = el("td");
+	for (const r of c.replicas) {
+		replicas.append(el("div", {}, r.name + " (" + (r.aliases || []).join(", ") + ")"));
+	}
+	inventory.append(el("tr", {}, el("td", {}, c.name), el("td", {}, c.image), el("td", {}, network), replicas));
+}
+
+// Dependencies.
+const deps = document.getElementById("deps");
+if (doc.dependencies.length === 0) {
+	deps.hidden = true;
+	document.getElementById("deps-title").hidden = true;
+}
+for (const d of doc.dependencies) {
+	const kind = d.condition ? d.kind + " (" + d.condition + ")" : d.kind;
+	deps.append(el("tr", {}, el("td", {}, d.from), el("td", {}, d.to), el("td", {}, kind),
+		el("td", {}, seconds(d.waited_seconds))));
+}
+
+// Event logs, collapsed by default and filtered by the search box.
+const logs = document.getElementById("logs");
+const entries = [];
+for (const row of data.rows) {
+	const e = byId.get(row.id);
+	const pre = el("pre", {}, row.log);
+	const summary = el("summary", {},
+		String(e.id).padStart(data.id_width, "0") + " " + "  ".repeat(row.depth) + e.description +
+		" (" + seconds(e.duration_seconds) + ")");
+	const details = el("details", {id: "event-" + e.id}, summary, pre);
+	entries.push({details, pre, text: row.log, haystack: (e.description + "\n" + row.log).toLowerCase()});
+	logs.append(details);
+}
+
+function showLog(id) {
+	const details = document.getElementById("event-" + id);
+	for (const d of document.querySelectorAll("details.highlight")) {
+		d.classList.remove("highlight");
+	}
+	details.hidden = false;
+	details.open = true;
+	details.classList.add("highlight");
+	details.scrollIntoView({block: "start"});
+}
+
+function highlight(pre, text, query) {
+	pre.textContent = "";
+	if (!query) {
+		pre.textContent = text;
+		return;
+	}
+	const lower = text.toLowerCase();
+	let i = 0;
+	for (let j = lower.indexOf(query); j >= 0; j = lower.indexOf(query, i)) {
+		pre.append(text.slice(i, j), el("mark", {}, text.slice(j, j + query.length)));
+		i = j + query.length;
+	}
+	pre.append(text.slice(i));
+}
+
+document.getElementById("search").oninput = ev => {
+	const query = ev.target.value.toLowerCase();
+	let matches = 0;
+	for (const entry of entries) {
+		const match = !query || entry.haystack.includes(query);
+		entry.details.hidden = !match;
+		highlight(entry.pre, entry.text, query);
+		if (match) {
+			matches++;
+			entry.details.open = query !== "" && entry.text.toLowerCase().includes(query);
+		}
+	}
+	document.getElementById("matches").textContent = query ? matches + " matching events" : "";
+};
+document.getElementById("expand").onclick = () => {
+	for (const entry of entries) {
+		entry.details.open = !entry.details.hidden;
+	}
+};
+document.getElementById("collapse").onclick = () => {
+	for (const entry of entries) {
+		entry.details.open = false;
+	}
+};
+</script>
+</body>
+</html>
+`))
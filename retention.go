@@ -0,0 +1,80 @@
+package testworld
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// LogRetention decides when the full world log of a test is kept. See
+// WithLogRetention.
+type LogRetention int
+
+const (
+	// LogAlways keeps the full world log of every test. This is the default.
+	LogAlways LogRetention = iota
+
+	// LogOnFailure keeps the full world log of failed tests only.
+	LogOnFailure
+
+	// LogNever never keeps the full world log.
+	LogNever
+)
+
+// keepFull reports whether the full world log is kept for a test that
+// failed or passed.
+func (r LogRetention) keepFull(failed bool) bool {
+	switch r {
+	case LogOnFailure:
+		return failed
+	case LogNever:
+		return false
+	default:
+		return true
+	}
+}
+
+// track registers a file written next to the world log during the test, so
+// it can be removed if the full log is not kept.
+func (el *WorldLog) track(path string) {
+	el.rw.Lock()
+	el.artifacts = append(el.artifacts, path)
+	el.rw.Unlock()
+}
+
+// finishSummary ends a world log whose full log is not kept. The files
+// written during the test are removed, and the combined log either holds
+// only the summary or is removed too.
+func (el *WorldLog) finishSummary() error {
+	var errs []error
+	remove := func(path string) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	el.rw.RLock()
+	for _, path := range el.artifacts {
+		remove(path)
+	}
+	el.rw.RUnlock()
+	el.world.mu.Lock()
+	for _, c := range el.world.captures {
+		remove(c.path)
+	}
+	el.world.captures = nil
+	el.world.mu.Unlock()
+
+	if !el.world.opts.logSummary {
+		el.combinedLog.Close()
+		remove(el.combinedLogPath)
+		return errors.Join(errs...)
+	}
+
+	el.printInventory()
+	el.printGantt()
+	el.printCriticalPath()
+	log.Printf("🌍 World destroyed, summary written to %s", el.combinedLogPath)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,246 @@
+package testworld
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// defaultStatsInterval is the sampling interval of WithResourceStats unless
+// configured otherwise.
+const defaultStatsInterval = time.Second
+
+// StatsSample is the resource usage of a replica at one point in time. The
+// network and block IO counters are totals since the replica started.
+type StatsSample struct {
+	Time            time.Time
+	CPUPercent      float64 // 100 is one CPU fully used
+	MemoryBytes     uint64  // excluding the page cache, like docker stats
+	NetRxBytes      uint64
+	NetTxBytes      uint64
+	BlockReadBytes  uint64
+	BlockWriteBytes uint64
+}
+
+// ResourceStats is the resource usage sampled for a replica, see
+// WithResourceStats.
+type ResourceStats struct {
+	Replica         string
+	Samples         []StatsSample
+	PeakCPUPercent  float64
+	PeakMemoryBytes uint64
+}
+
+// replicaStats samples the resource usage of one replica in the background.
+type replicaStats struct {
+	replica string
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu      sync.Mutex
+	samples []StatsSample
+}
+
+// add records a sample.
+func (rs *replicaStats) add(s StatsSample) {
+	rs.mu.Lock()
+	rs.samples = append(rs.samples, s)
+	rs.mu.Unlock()
+}
+
+// summary returns the samples so far and their peaks.
+func (rs *replicaStats) summary() ResourceStats {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	res := ResourceStats{Replica: rs.replica, Samples: append([]StatsSample(nil), rs.samples...)}
+	for _, s := range rs.samples {
+		res.PeakCPUPercent = max(res.PeakCPUPercent, s.CPUPercent)
+		res.PeakMemoryBytes = max(res.PeakMemoryBytes, s.MemoryBytes)
+	}
+	return res
+}
+
+// statsHooks returns lifecycle hooks that start sampling the replica's
+// resource usage as soon as it is running.
+func (w *World) statsHooks(pc *pendingContainer) testcontainers.ContainerLifecycleHooks {
+	return testcontainers.ContainerLifecycleHooks{
+		PostStarts: []testcontainers.ContainerHook{
+			func(_ context.Context, c testcontainers.Container) error {
+				w.sampleStats(pc, c.GetContainerID())
+				return nil
+			},
+		},
+	}
+}
+
+// sampleStats records the resource usage of the container with the given ID
+// every stats interval until it exits or stopSampling is called. Docker
+// produces stats about once a second, which bounds the interval.
+func (w *World) sampleStats(pc *pendingContainer, id string) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	res, err := w.docker.ContainerStats(ctx, id, client.ContainerStatsOptions{Stream: true})
+	if err != nil {
+		cancel()
+		w.t.Log("Failed to sample stats of ", pc.name, ": ", err)
+		return
+	}
+
+	rs := &replicaStats{replica: pc.name, cancel: cancel, done: make(chan struct{})}
+	pc.mu.Lock()
+	pc.stats = rs
+	pc.mu.Unlock()
+	w.mu.Lock()
+	w.stats = append(w.stats, rs)
+	w.mu.Unlock()
+
+	go func() {
+		defer close(rs.done)
+		defer res.Body.Close()
+		dec := json.NewDecoder(res.Body)
+		var last time.Time
+		for {
+			var resp container.StatsResponse
+			if err := dec.Decode(&resp); err != nil {
+				return
+			}
+			// A stopped container reports zero stats.
+			if resp.Read.IsZero() || resp.Read.Sub(last) < w.opts.statsInterval {
+				continue
+			}
+			last = resp.Read
+			rs.add(statsSample(&resp))
+		}
+	}()
+}
+
+// stopSampling stops sampling the replica's resource usage. The samples so
+// far are kept.
+func (pc *pendingContainer) stopSampling() {
+	pc.mu.Lock()
+	rs := pc.stats
+	pc.mu.Unlock()
+	if rs != nil {
+		rs.cancel()
+		<-rs.done
+	}
+}
+
+// statsSample converts Docker stats to a sample, computing the CPU usage
+// from the previous reading like docker stats does.
+func statsSample(resp *container.StatsResponse) StatsSample {
+	s := StatsSample{Time: resp.Read}
+
+	cpu, pre := resp.CPUStats, resp.PreCPUStats
+	cpus := uint64(cpu.OnlineCPUs)
+	if cpus == 0 {
+		cpus = uint64(len(cpu.CPUUsage.PercpuUsage))
+	}
+	// The first reading of a stream has no previous reading.
+	if pre.SystemUsage > 0 && cpu.CPUUsage.TotalUsage > pre.CPUUsage.TotalUsage && cpu.SystemUsage > pre.SystemUsage {
+		cpuDelta := float64(cpu.CPUUsage.TotalUsage - pre.CPUUsage.TotalUsage)
+		systemDelta := float64(cpu.SystemUsage - pre.SystemUsage)
+		s.CPUPercent = cpuDelta / systemDelta * float64(cpus) * 100
+	}
+
+	mem := resp.MemoryStats
+	cache := mem.Stats["inactive_file"] // cgroup v2
+	if v, ok := mem.Stats["total_inactive_file"]; ok {
+		cache = v // cgroup v1
+	}
+	if mem.Usage > cache {
+		s.MemoryBytes = mem.Usage - cache
+	}
+
+	for _, n := range resp.Networks {
+		s.NetRxBytes += n.RxBytes
+		s.NetTxBytes += n.TxBytes
+	}
+	for _, e := range resp.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			s.BlockReadBytes += e.Value
+		case "write":
+			s.BlockWriteBytes += e.Value
+		}
+	}
+	return s
+}
+
+// ResourceStats returns the resource usage sampled so far for each replica,
+// in replica order. It returns nil unless the world was created with
+// WithResourceStats.
+func (wc *WorldContainer) ResourceStats() []ResourceStats {
+	var stats []ResourceStats
+	for _, pc := range wc.replicas() {
+		pc.mu.Lock()
+		rs := pc.stats
+		pc.mu.Unlock()
+		if rs != nil {
+			stats = append(stats, rs.summary())
+		}
+	}
+	return stats
+}
+
+// printResourceStats writes a table of the peak and final resource usage of
+// every sampled replica to the world log, followed by the samples as time
+// series.
+func (el *WorldLog) printResourceStats() {
+	el.world.mu.Lock()
+	sampled := el.world.stats
+	el.world.mu.Unlock()
+	if len(sampled) == 0 {
+		return
+	}
+	stats := make([]ResourceStats, len(sampled))
+	for i, rs := range sampled {
+		stats[i] = rs.summary()
+	}
+
+	fmt.Fprintln(el.combinedLog, "Resource Usage:")
+	tw := tabwriter.NewWriter(el.combinedLog, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Replica\tSamples\tPeak CPU\tPeak Memory\tNet RX\tNet TX\tBlock Read\tBlock Write")
+	for _, s := range stats {
+		var final StatsSample
+		if len(s.Samples) > 0 {
+			final = s.Samples[len(s.Samples)-1]
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%.1f%%\t%s\t%s\t%s\t%s\t%s\n", s.Replica, len(s.Samples),
+			s.PeakCPUPercent, formatBytes(s.PeakMemoryBytes), formatBytes(final.NetRxBytes),
+			formatBytes(final.NetTxBytes), formatBytes(final.BlockReadBytes), formatBytes(final.BlockWriteBytes))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(el.combinedLog, "\nResource Samples:")
+	for _, s := range stats {
+		fmt.Fprintf(el.combinedLog, "  %s\n", s.Replica)
+		for _, p := range s.Samples {
+			fmt.Fprintf(el.combinedLog, "    at %7.3fs  cpu=%.1f%% mem=%s net_rx=%s net_tx=%s blk_read=%s blk_write=%s\n",
+				p.Time.Sub(el.startTime).Seconds(), p.CPUPercent, formatBytes(p.MemoryBytes),
+				formatBytes(p.NetRxBytes), formatBytes(p.NetTxBytes), formatBytes(p.BlockReadBytes), formatBytes(p.BlockWriteBytes))
+		}
+	}
+	fmt.Fprintln(el.combinedLog)
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "12.3MiB".
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
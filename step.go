@@ -0,0 +1,83 @@
+package testworld
+
+import "slices"
+
+// Step is a named span of a test, created with World.Step. Operations called
+// while the step is open, such as Exec and Wait, become its children: they
+// are indented below it in the Gantt chart and their logs are grouped after
+// it in the combined log. Background work, such as DNS queries or replicas
+// whose creation started before the step, is not part of it.
+type Step struct {
+	el    *WorldLog
+	event *Event
+}
+
+// Step starts a named test step, e.g. "seed data". A step started while
+// another is open is nested in it. Call End when the step is done.
+func (w *World) Step(name string) *Step {
+	event := w.worldLog.newStepEvent(w.worldLog.currentStep(), "step", "Step: %s", name)
+	w.worldLog.pushStep(event)
+	return &Step{el: w.worldLog, event: event}
+}
+
+// End finishes the step, together with any steps nested in it that are still
+// open.
+func (s *Step) End() {
+	for _, e := range s.el.popStep(s.event) {
+		e.finish()
+	}
+}
+
+// currentStep returns the innermost open step. Operations called by the test
+// look it up when they start and pass it as the parent of their events, so
+// work running in the background is never attributed to an unrelated step.
+func (el *WorldLog) currentStep() *Event {
+	if el == nil {
+		return nil
+	}
+	el.rw.RLock()
+	defer el.rw.RUnlock()
+	if len(el.steps) == 0 {
+		return nil
+	}
+	return el.steps[len(el.steps)-1]
+}
+
+// pushStep makes event the innermost open step.
+func (el *WorldLog) pushStep(event *Event) {
+	if event == nil {
+		return
+	}
+	el.rw.Lock()
+	el.steps = append(el.steps, event)
+	el.rw.Unlock()
+}
+
+// popStep closes event and the steps nested in it, returning them innermost
+// first. It returns nothing if event is not open.
+func (el *WorldLog) popStep(event *Event) []*Event {
+	if event == nil {
+		return nil
+	}
+	el.rw.Lock()
+	defer el.rw.Unlock()
+	i := slices.Index(el.steps, event)
+	if i < 0 {
+		return nil
+	}
+	closed := slices.Clone(el.steps[i:])
+	slices.Reverse(closed)
+	el.steps = el.steps[:i]
+	return closed
+}
+
+// endSteps finishes all steps that are still open.
+func (el *WorldLog) endSteps() {
+	el.rw.Lock()
+	open := el.steps
+	el.steps = nil
+	el.rw.Unlock()
+	for i := len(open) - 1; i >= 0; i-- {
+		open[i].finish()
+	}
+}
@@ -2,18 +2,23 @@ package testworld
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"maps"
+	"net/netip"
 	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	dockernetwork "github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/client"
 	"github.com/testcontainers/testcontainers-go"
-	tcexec "github.com/testcontainers/testcontainers-go/exec"
 	"github.com/testcontainers/testcontainers-go/network"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -22,26 +27,57 @@ const (
 	timelineWidth = 80
 )
 
-// sharedNetworks holds a single pair of Docker networks shared by all World
-// instances in this test binary. Networks are created on first use and removed
-// when the last World is destroyed.
+// networkConfig identifies a pair of shared networks by their subnets. The
+// zero value lets Docker choose the subnets.
+type networkConfig struct {
+	external netip.Prefix
+	internal netip.Prefix
+}
+
+// sharedNetworks holds the Docker networks shared by all World instances in
+// this test binary, one pair per subnet configuration. Networks are created on
+// first use and removed when the last World using them is destroyed.
 type sharedNetworks struct {
-	mu   sync.Mutex
-	cn   *testcontainers.DockerNetwork // external bridge
-	icn  *testcontainers.DockerNetwork // internal bridge
-	refs int
+	mu    sync.Mutex
+	pairs map[networkConfig]*networkPair
+}
+
+// networkPair is an external and an internal network created with the same
+// networkConfig.
+type networkPair struct {
+	cn    *testcontainers.DockerNetwork // external bridge
+	icn   *testcontainers.DockerNetwork // internal bridge
+	refs  int
+	addrs map[netip.Addr]string // pinned internal addresses -> container name
 }
 
 var shared sharedNetworks
 
-// acquire increments the reference count and returns the shared networks,
-// creating them first if no World currently holds a reference.
+// acquire increments the reference count and returns the shared networks for
+// cfg, creating them first if no World currently holds a reference.
 // Every acquire must be paired with exactly one release.
-func (s *sharedNetworks) acquire(ctx context.Context, t *testing.T) (external, internal *testcontainers.DockerNetwork) {
+func (s *sharedNetworks) acquire(ctx context.Context, t *testing.T, cfg networkConfig) (external, internal *testcontainers.DockerNetwork) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.refs == 0 {
+	if s.pairs == nil {
+		s.pairs = make(map[networkConfig]*networkPair)
+	}
+
+	p := s.pairs[cfg]
+	if p == nil {
+		// Docker refuses overlapping subnets, but its error does not say
+		// which network is in the way. Check our own networks first.
+		for other := range s.pairs {
+			for _, mine := range []netip.Prefix{cfg.external, cfg.internal} {
+				for _, theirs := range []netip.Prefix{other.external, other.internal} {
+					if mine.IsValid() && theirs.IsValid() && mine.Overlaps(theirs) {
+						t.Fatalf("Subnet %s overlaps subnet %s used by another world", mine, theirs)
+					}
+				}
+			}
+		}
+
 		// No live World holds the networks; create a fresh pair.
 		type result struct {
 			net *testcontainers.DockerNetwork
@@ -51,42 +87,50 @@ func (s *sharedNetworks) acquire(ctx context.Context, t *testing.T) (external, i
 		intCh := make(chan result, 1)
 
 		go func() {
-			n, err := network.New(ctx, network.WithDriver("bridge"), network.WithAttachable())
+			opts := []network.NetworkCustomizer{network.WithDriver("bridge"), network.WithAttachable()}
+			opts = append(opts, subnetOptions(cfg.external)...)
+			n, err := network.New(ctx, opts...)
 			extCh <- result{n, err}
 		}()
 		go func() {
-			n, err := network.New(ctx, network.WithDriver("bridge"), network.WithAttachable(), network.WithInternal())
+			opts := []network.NetworkCustomizer{network.WithDriver("bridge"), network.WithAttachable(), network.WithInternal()}
+			opts = append(opts, subnetOptions(cfg.internal)...)
+			n, err := network.New(ctx, opts...)
 			intCh <- result{n, err}
 		}()
 
 		ext, int_ := <-extCh, <-intCh
 		if ext.err != nil {
-			t.Fatalf("Failed to create external network: %v", ext.err)
+			t.Fatalf("Failed to create external network%s: %v", subnetHint(cfg.external), ext.err)
 		}
 		if int_.err != nil {
-			t.Fatalf("Failed to create internal network: %v", int_.err)
+			t.Fatalf("Failed to create internal network%s: %v", subnetHint(cfg.internal), int_.err)
 		}
-		s.cn = ext.net
-		s.icn = int_.net
+		p = &networkPair{cn: ext.net, icn: int_.net, addrs: make(map[netip.Addr]string)}
+		s.pairs[cfg] = p
 	}
 
-	s.refs++
-	return s.cn, s.icn
+	p.refs++
+	return p.cn, p.icn
 }
 
-// release decrements the reference count and removes the shared networks
-// when it reaches zero.
-func (s *sharedNetworks) release(ctx context.Context, t *testing.T) {
+// release decrements the reference count and removes the shared networks for
+// cfg when it reaches zero.
+func (s *sharedNetworks) release(ctx context.Context, t *testing.T, cfg networkConfig) {
 	s.mu.Lock()
-	s.refs--
-	if s.refs > 0 {
+	p := s.pairs[cfg]
+	if p == nil {
 		s.mu.Unlock()
 		return
 	}
-	// Capture and clear the pointers under the lock so a concurrent acquire
-	// sees refs == 0 and cn == nil, triggering fresh network creation.
-	cn, icn := s.cn, s.icn
-	s.cn, s.icn = nil, nil
+	p.refs--
+	if p.refs > 0 {
+		s.mu.Unlock()
+		return
+	}
+	// Remove the pair under the lock so a concurrent acquire finds no
+	// networks for cfg, triggering fresh network creation.
+	delete(s.pairs, cfg)
 	s.mu.Unlock()
 
 	docker, err := client.New(client.FromEnv)
@@ -95,16 +139,66 @@ func (s *sharedNetworks) release(ctx context.Context, t *testing.T) {
 		return
 	}
 	defer docker.Close()
-	if cn != nil {
+	if p.cn != nil {
 		//nolint:errcheck
-		docker.NetworkRemove(ctx, cn.Name, client.NetworkRemoveOptions{})
+		docker.NetworkRemove(ctx, p.cn.Name, client.NetworkRemoveOptions{})
 	}
-	if icn != nil {
+	if p.icn != nil {
 		//nolint:errcheck
-		docker.NetworkRemove(ctx, icn.Name, client.NetworkRemoveOptions{})
+		docker.NetworkRemove(ctx, p.icn.Name, client.NetworkRemoveOptions{})
 	}
 }
 
+// reserve records that owner has pinned addr on the internal network for cfg.
+// It fails if another container, possibly in another World, already holds addr.
+func (s *sharedNetworks) reserve(cfg networkConfig, addr netip.Addr, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pairs[cfg]
+	if p == nil {
+		return fmt.Errorf("no networks for subnet %s", cfg.internal)
+	}
+	if holder, ok := p.addrs[addr]; ok {
+		return fmt.Errorf("address %s is already assigned to %s", addr, holder)
+	}
+	p.addrs[addr] = owner
+	return nil
+}
+
+// unreserve frees addresses previously pinned with reserve.
+func (s *sharedNetworks) unreserve(cfg networkConfig, addrs []netip.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p := s.pairs[cfg]; p != nil {
+		for _, addr := range addrs {
+			delete(p.addrs, addr)
+		}
+	}
+}
+
+// subnetOptions returns the network options that pin a network to subnet.
+// The first host address is used as the gateway, matching Docker's default.
+func subnetOptions(subnet netip.Prefix) []network.NetworkCustomizer {
+	if !subnet.IsValid() {
+		return nil
+	}
+	return []network.NetworkCustomizer{network.WithIPAM(&dockernetwork.IPAM{
+		Driver: "default",
+		Config: []dockernetwork.IPAMConfig{{
+			Subnet:  subnet,
+			Gateway: subnet.Addr().Next(),
+		}},
+	})}
+}
+
+// subnetHint formats a configured subnet for error messages.
+func subnetHint(subnet netip.Prefix) string {
+	if !subnet.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf(" with subnet %s (does it overlap an existing Docker network or VPN route?)", subnet)
+}
+
 // basename returns the last component of a path, stripping any suffix after ":".
 // e.g., "alpine:latest" -> "alpine", "docker.io/library/nginx:1.19" -> "nginx"
 func basename(s string) string {
@@ -127,10 +221,24 @@ type World struct {
 	worldLog       *WorldLog
 	cn             *testcontainers.DockerNetwork // external: bridge with internet access
 	icn            *testcontainers.DockerNetwork // internal: no internet, shared by all containers
+	netCfg         networkConfig                 // subnets of cn and icn
+	pinned         []netip.Addr                  // addresses reserved via ContainerSpec.IPv4Address
 	containers     map[string]WorldContainer
+	order          []string // container group names in creation order
 	containerKinds map[string]int
 	tls            *worldCA
+	dns            *worldDNS // world DNS resolver, nil unless enabled with WithDNS
 	docker         *client.Client
+	opts           worldOptions // options passed to New, with defaults applied
+	streamMu       sync.Mutex   // serializes lines written to ContainerSpec.LogTo
+	secrets        redactor     // secrets masked in the world log and reports
+
+	// mu guards state that is updated from container creation goroutines.
+	mu              sync.Mutex
+	captures        []*capture      // packet captures saved into the log directory on Destroy
+	networkCaptures int             // World.Capture calls so far, numbering their files
+	stats           []*replicaStats // resource usage of every sampled replica
+	deps            []depEdge       // dependency graph of the container groups
 }
 
 // pendingContainer holds the result of an async container creation.
@@ -138,27 +246,59 @@ type World struct {
 // happens-before ordering per the Go memory model.
 type pendingContainer struct {
 	name      string
+	group     string   // name of the container group
+	index     int      // replica number within the group, starting at 1
 	aliases   []string // DNS aliases registered on the shared networks
+	certPEM   []byte   // TLS certificate, kept when the replica is recreated
+	keyPEM    []byte
 	ready     chan struct{}
 	container testcontainers.Container
 	err       error
+
+	// mu guards the fields below, which helpers update while the
+	// container runs.
+	mu        sync.Mutex
+	sidecars  []testcontainers.Container // helpers removed together with the container
+	denied    []string                   // egress destinations blocked by the allowlist
+	blockedOn *WorldContainer            // Requires dependency creation waits for
+	follower  *follower                  // streams the output, see ContainerSpec.LogTo
+	stats     *replicaStats              // samples resource usage, see WithResourceStats
+
+	// event is the creation event, set before ready is closed. phase is
+	// the current phase of creation, only used by the creating goroutine.
+	event *Event
+	phase *Event
+
+	// inUse is held for reading while an operation runs against the
+	// container, and for writing by Scale before it removes the replica.
+	inUse   sync.RWMutex
+	removed bool // set by Scale under inUse; operations skip removed replicas
+}
+
+// addSidecar registers a helper container so Destroy removes it.
+func (pc *pendingContainer) addSidecar(c testcontainers.Container) {
+	pc.mu.Lock()
+	pc.sidecars = append(pc.sidecars, c)
+	pc.mu.Unlock()
 }
 
 type WorldContainer struct {
 	world     *World
 	Name      string
-	image     string // image name, or "dockerfile:<context>" for custom builds
 	isolated  bool
-	isReady   bool
-	pending   []*pendingContainer
+	egress    []string // EgressAllow entries, if the allowlist is enabled
+	address   string   // pinned internal IPv4 address, if any
+	group     *replicaGroup
 	after     []WorldContainer
+	cond      *depCondition // condition dependants wait for, nil for started
 	onDestroy func(WorldContainer)
 }
 
 // New creates a new testworld. w.Destroy() should be deferred right after
 // calling this function. If logPath is not empty, a world log will be
-// created in the specified directory.
-func New(t *testing.T, logPath string) *World {
+// created in the specified directory. Options tune optional behaviour such as
+// the network subnets.
+func New(t *testing.T, logPath string, opts ...Option) *World {
 	var w World
 
 	// All tests in this package run in isolated worlds, so they should be
@@ -170,15 +310,31 @@ func New(t *testing.T, logPath string) *World {
 		t.Skip("skipping testworld test in short mode")
 	}
 
+	var o worldOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	netCfg, err := o.networkConfig()
+	if err != nil {
+		t.Fatalf("Invalid world options: %v", err)
+	}
+
 	w.t = t
+	w.netCfg = netCfg
 	w.name = strings.ReplaceAll(t.Name(), "/", "-")
 	w.ctx = context.Background()
 	w.containers = make(map[string]WorldContainer)
 	w.containerKinds = make(map[string]int)
+	o.awaitReport = cmp.Or(o.awaitReport, defaultAwaitReport)
+	o.conditionTimeout = cmp.Or(o.conditionTimeout, defaultConditionTimeout)
+	w.opts = o
+	w.secrets.add(o.secrets, o.secretPatterns)
 
 	// Creating a world log is optional. If logPath is empty, we use a
-	// dummy world log that does nothing.
-	if logPath != "" {
+	// dummy world log that does nothing, unless events are traced to
+	// explicit exporters or failure logs are printed, which need the
+	// events but no log files.
+	if logPath != "" || len(o.spanExporters) > 0 || o.failureTail > 0 {
 		// Use test tmpdir for intermediate logs
 		logDir := fmt.Sprintf("%s/worldlogs", t.TempDir())
 		if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -198,6 +354,14 @@ func New(t *testing.T, logPath string) *World {
 		w.worldLog = &WorldLog{}
 	}
 
+	if o.tracing {
+		if logPath == "" && len(o.spanExporters) == 0 {
+			t.Log("Tracing requires a world log path or span exporters, skipping")
+		} else if err := w.worldLog.startTracing(o.spanExporters); err != nil {
+			t.Log("Failed to start tracing:", err)
+		}
+	}
+
 	event := w.worldLog.newEvent("World: Create")
 	defer event.finish()
 
@@ -208,7 +372,22 @@ func New(t *testing.T, logPath string) *World {
 	w.docker = docker
 
 	// Acquire shared networks (created once, reused across all parallel tests).
-	w.cn, w.icn = shared.acquire(w.ctx, t)
+	w.cn, w.icn = shared.acquire(w.ctx, t, w.netCfg)
+
+	// Start the world DNS resolver before any container is created, since
+	// every container mounts its resolv.conf.
+	if o.dns != 0 {
+		dns, err := w.listenDNS(w.ctx, o.dns)
+		if err != nil {
+			w.Destroy()
+			t.Fatalf("Failed to start DNS resolver: %v", err)
+		}
+		w.dns = dns
+		if err := dns.start(w.ctx); err != nil {
+			w.Destroy()
+			t.Fatalf("Failed to start DNS resolver: %v", err)
+		}
+	}
 
 	// Generate a World-scoped CA so every container gets a TLS certificate.
 	// Certificates are mounted at TLSCACertPath, TLSCertPath, and TLSKeyPath.
@@ -222,9 +401,18 @@ func New(t *testing.T, logPath string) *World {
 	return &w
 }
 
+// groups returns the container groups of the world in creation order.
+func (w *World) groups() []WorldContainer {
+	groups := make([]WorldContainer, len(w.order))
+	for i, name := range w.order {
+		groups[i] = w.containers[name]
+	}
+	return groups
+}
+
 // AwaitAll waits for all containers in the world to be ready.
 func (w *World) AwaitAll() {
-	for _, c := range w.containers {
+	for _, c := range w.groups() {
 		c.Await()
 	}
 }
@@ -237,12 +425,13 @@ func (w *World) Destroy() {
 
 	// Wait for all containers to be ready before starting the teardown.
 	w.AwaitAll()
+	w.worldLog.endSteps()
 
-	event := w.worldLog.newEvent("World: destroy")
+	event := w.worldLog.newTypedEvent("destroy", "World: destroy")
 
 	// Collect logs from all containers concurrently.
 	var wg sync.WaitGroup
-	for _, c := range w.containers {
+	for _, c := range w.groups() {
 		wg.Add(1)
 		go func(c WorldContainer) {
 			defer wg.Done()
@@ -252,15 +441,21 @@ func (w *World) Destroy() {
 			}
 
 			var pwg sync.WaitGroup
-			for _, pc := range c.pending {
+			for _, pc := range c.replicas() {
 				pwg.Add(1)
 				go func(pc *pendingContainer) {
 					defer pwg.Done()
+					defer pc.stopFollowing()
+					defer pc.stopSampling()
 					if pc.err != nil {
 						w.t.Log("Container ", pc.name, " failed to create: ", pc.err)
 						return
 					}
-					if err := c.logOneInternal(pc.name, pc.container); err != nil {
+					if c.group.template().job {
+						// Job output was logged when the job finished.
+						return
+					}
+					if err := c.logOneInternal(pc, nil); err != nil {
 						w.t.Log("Failed to collect logs for container ", pc.name, ": ", err)
 					}
 				}(pc)
@@ -270,56 +465,71 @@ func (w *World) Destroy() {
 	}
 	wg.Wait()
 
+	if w.opts.failureTail > 0 && w.t.Failed() {
+		w.worldLog.logFailures(w.opts.failureTail)
+	}
+
+	// Stop packet captures and copy them next to the world log.
+	w.saveCaptures()
+
 	// Force-remove all containers concurrently using the Docker client
 	// directly. This skips testcontainers' Stop (SIGTERM → wait → SIGKILL)
 	// and lifecycle hooks, issuing a single SIGKILL+remove per container.
 	if w.docker != nil {
 		defer w.docker.Close()
 		var rmWg sync.WaitGroup
-		for _, c := range w.containers {
-			for _, pc := range c.pending {
-				if pc.err != nil {
-					continue
+		for _, c := range w.groups() {
+			for _, pc := range c.replicas() {
+				var ids []string
+				if pc.err == nil {
+					ids = append(ids, pc.container.GetContainerID())
+				}
+				pc.mu.Lock()
+				for _, sc := range pc.sidecars {
+					ids = append(ids, sc.GetContainerID())
+				}
+				pc.mu.Unlock()
+				for _, id := range ids {
+					rmWg.Add(1)
+					go func(id string) {
+						defer rmWg.Done()
+						//nolint:errcheck
+						w.docker.ContainerRemove(w.ctx, id, client.ContainerRemoveOptions{
+							RemoveVolumes: true,
+							Force:         true,
+						})
+					}(id)
 				}
-				rmWg.Add(1)
-				go func(id string) {
-					defer rmWg.Done()
-					//nolint:errcheck
-					w.docker.ContainerRemove(w.ctx, id, client.ContainerRemoveOptions{
-						RemoveVolumes: true,
-						Force:         true,
-					})
-				}(pc.container.GetContainerID())
 			}
 		}
 		rmWg.Wait()
 	}
 
+	// The resolver volume can only be removed once no container mounts it.
+	if w.dns != nil {
+		w.dns.close(w.ctx)
+	}
+
 	event.finish()
 	w.worldLog.finish()
 
 	// Release our reference to the shared networks.
 	// The last World to release removes them.
 	if w.cn != nil {
-		shared.release(w.ctx, w.t)
+		shared.unreserve(w.netCfg, w.pinned)
+		shared.release(w.ctx, w.t, w.netCfg)
 	}
 }
 
-// logOneInternal writes a single container's logs to the world log.
-func (wc *WorldContainer) logOneInternal(name string, container testcontainers.Container) error {
-	event := wc.world.worldLog.newEvent("%s: logs", name)
+// logOneInternal writes a single replica's logs to the world log, as part of
+// step if it is not nil.
+func (wc *WorldContainer) logOneInternal(pc *pendingContainer, step *Event) error {
+	event := wc.world.worldLog.newStepEvent(step, "logs", "%s: logs", pc.name)
 	defer event.finish()
+	event.setReplica(pc)
 
-	logsReader, err := container.Logs(wc.world.ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get logs: %w", err)
-	}
-	defer logsReader.Close()
-
-	if event != nil {
-		if _, err = io.Copy(event.log, logsReader); err != nil {
-			return fmt.Errorf("failed to copy logs: %w", err)
-		}
+	if err := wc.world.copyLogs(event, pc.name, pc.container.GetContainerID()); err != nil {
+		return fmt.Errorf("failed to copy logs: %w", err)
 	}
 	return nil
 }
@@ -329,6 +539,14 @@ func (wc *WorldContainer) logOneInternal(name string, container testcontainers.C
 // wait for it to be ready. Call Await() to explicitly block until ready.
 // Set spec.Replicas to create multiple identical containers as a group.
 func (w *World) NewContainer(spec ContainerSpec) WorldContainer {
+	return w.newContainer(spec, false)
+}
+
+// newContainer creates a container group, or a job group if job is set.
+func (w *World) newContainer(spec ContainerSpec, job bool) WorldContainer {
+	// Register the secrets first so the events below are masked too.
+	w.secrets.add(spec.Secrets, spec.SecretPatterns)
+
 	// Derive kind from image name, or dockerfile context as fallback
 	kind := basename(spec.Image)
 	if kind == "" {
@@ -346,179 +564,328 @@ func (w *World) NewContainer(spec ContainerSpec) WorldContainer {
 	name := strings.ToLower(fmt.Sprintf("%s-%s-%d", w.name, kind, w.containerKinds[kind]))
 
 	replicas := max(spec.Replicas, 1)
-	pending := make([]*pendingContainer, replicas)
-
-	// Derive a human-readable image label for the inventory log.
-	imageLabel := spec.Image
-	if imageLabel == "" {
-		ctx := spec.FromDockerfile.Context
-		if ctx == "" {
-			ctx = "<archive>"
+
+	// Egress and capture helpers join the container's network namespace,
+	// which is gone once a job has exited.
+	if job && (len(spec.EgressAllow) > 0 || spec.Capture) {
+		w.t.Fatalf("Job %s cannot use EgressAllow or Capture, as it may exit before their helpers start", name)
+	}
+
+	if len(spec.EgressAllow) > 0 {
+		if spec.Isolated {
+			w.t.Fatalf("Container %s cannot combine Isolated and EgressAllow", name)
+		}
+		if err := validateEgressAllow(spec.EgressAllow); err != nil {
+			w.t.Fatalf("Invalid EgressAllow for container %s: %v", name, err)
+		}
+	}
+
+	if err := w.addDependencies(name, spec); err != nil {
+		w.t.Fatalf("Invalid dependencies for container %s: %v", name, err)
+	}
+
+	if spec.IPv4Address != "" {
+		if err := w.pinAddress(spec.IPv4Address, name, replicas); err != nil {
+			w.t.Fatalf("Invalid IPv4Address for container %s: %v", name, err)
 		}
-		imageLabel = "dockerfile:" + ctx
 	}
 
+	tmpl := w.newReplicaTemplate(spec, kind, name)
+	tmpl.job = job
+
 	wc := WorldContainer{
 		world:     w,
 		Name:      name,
-		image:     imageLabel,
 		isolated:  spec.Isolated,
-		pending:   pending,
+		egress:    spec.EgressAllow,
+		address:   spec.IPv4Address,
+		group:     newReplicaGroup(tmpl, replicas > 1),
 		after:     spec.After,
 		onDestroy: spec.OnDestroy,
 	}
 
 	// Add the container to the world synchronously so Destroy() can find it
 	w.containers[name] = wc
+	w.order = append(w.order, name)
 
-	// Buffer any io.Reader-based file contents once before spawning replica
-	// goroutines. An io.Reader can only be consumed once, so each replica must
-	// get its own independent bytes.Reader over the same underlying bytes.
-	fileContents := make([][]byte, len(spec.Files))
-	for i, f := range spec.Files {
-		if f.Reader != nil {
-			data, err := io.ReadAll(f.Reader)
-			if err != nil {
-				w.t.Fatalf("Failed to buffer file %q for container %s: %v", f.ContainerFilePath, name, err)
-			}
-			fileContents[i] = data
+	step := w.worldLog.currentStep()
+	for range replicas {
+		if err := w.addReplica(wc.group, step); err != nil {
+			w.t.Fatalf("Failed to add replica to %s: %v", name, err)
 		}
 	}
 
-	// Buffer ContextArchive (io.ReadSeeker) for the same reason: the first
-	// replica goroutine to run would exhaust the reader, leaving every other
-	// replica with an empty archive.
-	var contextArchiveData []byte
-	if spec.FromDockerfile.ContextArchive != nil {
-		data, err := io.ReadAll(spec.FromDockerfile.ContextArchive)
-		if err != nil {
-			w.t.Fatalf("Failed to buffer ContextArchive for container %s: %v", name, err)
-		}
-		contextArchiveData = data
-	}
+	return wc
+}
 
-	for i := range replicas {
-		// For a single replica, the replica name is the group name.
-		// For multiple replicas, each gets a unique suffix.
-		replicaName := name
-		aliases := []string{name}
-		if replicas > 1 {
-			replicaName = fmt.Sprintf("%s-%d", name, i+1)
-			aliases = []string{replicaName, name}
-		}
-		aliases = append(aliases, spec.Aliases...)
-
-		// Expand subdomains: join each subdomain with each existing alias.
-		if len(spec.Subdomains) > 0 {
-			base := make([]string, len(aliases))
-			copy(base, aliases)
-			for _, sub := range spec.Subdomains {
-				for _, a := range base {
-					aliases = append(aliases, sub+"."+a)
-				}
+// addReplica starts creating the group's next replica in the background and
+// adds it to the group.
+func (w *World) addReplica(g *replicaGroup, step *Event) error {
+	tmpl := g.template()
+	spec := tmpl.spec
+	name := tmpl.name
+
+	index := g.freeIndex()
+
+	// For a single replica, the replica name is the group name.
+	// For multiple replicas, each gets a unique suffix.
+	replicaName := name
+	aliases := []string{name}
+	if g.suffixed || index > 1 {
+		replicaName = fmt.Sprintf("%s-%d", name, index)
+		aliases = []string{replicaName, name}
+	}
+	aliases = append(aliases, spec.Aliases...)
+
+	// Expand subdomains: join each subdomain with each existing alias.
+	if len(spec.Subdomains) > 0 {
+		base := make([]string, len(aliases))
+		copy(base, aliases)
+		for _, sub := range spec.Subdomains {
+			for _, a := range base {
+				aliases = append(aliases, sub+"."+a)
 			}
 		}
+	}
 
-		pc := &pendingContainer{
-			name:    replicaName,
-			aliases: aliases,
-			ready:   make(chan struct{}),
-		}
-		pending[i] = pc
+	pc := &pendingContainer{
+		name:    replicaName,
+		group:   name,
+		index:   index,
+		aliases: aliases,
+		ready:   make(chan struct{}),
+	}
+	containerRequest, err := w.replicaRequest(tmpl, pc)
+	if err != nil {
+		return err
+	}
 
-		containerRequest := spec.toGenericContainerRequest(replicaName, w.cn.Name, w.icn.Name, aliases)
+	g.add(pc)
+	go w.createReplica(tmpl, pc, containerRequest, step)
+	return nil
+}
 
-		// Give this replica its own readers so goroutines don't race over
-		// shared io.Reader state. HostFilePath-based files are unaffected.
-		replicaFiles := make([]testcontainers.ContainerFile, len(spec.Files))
-		copy(replicaFiles, spec.Files)
-		for j, data := range fileContents {
-			if data != nil {
-				replicaFiles[j].Reader = bytes.NewReader(data)
-			}
+// replicaRequest builds the container request for a replica from the group
+// template. The replica's TLS certificate is generated on first use and
+// reused when the replica is recreated.
+func (w *World) replicaRequest(tmpl *replicaTemplate, pc *pendingContainer) (testcontainers.GenericContainerRequest, error) {
+	spec := tmpl.spec
+	containerRequest := spec.toGenericContainerRequest(pc.name, w.cn.Name, w.icn.Name, pc.aliases)
+
+	// Give this replica its own readers so goroutines don't race over
+	// shared io.Reader state. HostFilePath-based files are unaffected.
+	replicaFiles := make([]testcontainers.ContainerFile, len(spec.Files))
+	copy(replicaFiles, spec.Files)
+	for j, data := range tmpl.fileContents {
+		if data != nil {
+			replicaFiles[j].Reader = bytes.NewReader(data)
 		}
-		containerRequest.ContainerRequest.Files = replicaFiles
+	}
+	containerRequest.ContainerRequest.Files = replicaFiles
 
-		// If TLS is enabled, generate a certificate for this replica and
-		// mount the CA cert, leaf cert, and key into the container.
-		if w.tls != nil {
-			certPEM, keyPEM, err := w.tls.generateCert(aliases)
+	// If TLS is enabled, generate a certificate for this replica and
+	// mount the CA cert, leaf cert, and key into the container.
+	if w.tls != nil {
+		if pc.certPEM == nil {
+			certPEM, keyPEM, err := w.tls.generateCert(pc.aliases)
 			if err != nil {
-				w.t.Fatalf("Failed to generate TLS cert for %s: %v", replicaName, err)
+				return containerRequest, fmt.Errorf("generate TLS cert for %s: %w", pc.name, err)
 			}
-			containerRequest.ContainerRequest.Files = append(containerRequest.ContainerRequest.Files,
-				testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.certPEM), ContainerFilePath: TLSCACertPath, FileMode: 0o644},
-				testcontainers.ContainerFile{Reader: bytes.NewReader(certPEM), ContainerFilePath: TLSCertPath, FileMode: 0o644},
-				testcontainers.ContainerFile{Reader: bytes.NewReader(keyPEM), ContainerFilePath: TLSKeyPath, FileMode: 0o644},
-				// Place the CA in the OS trust store directory so
-				// update-ca-certificates can pick it up.
-				testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.certPEM), ContainerFilePath: "/usr/local/share/ca-certificates/testworld-ca.crt", FileMode: 0o644},
-				// Mount the pre-built combined CA bundle directly into
-				// the well-known trust store paths, avoiding per-container
-				// Docker API calls to read-modify-write the trust store.
-				testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.bundlePEM), ContainerFilePath: "/etc/ssl/certs/ca-certificates.crt", FileMode: 0o644},
-				testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.bundlePEM), ContainerFilePath: "/etc/pki/tls/certs/ca-bundle.crt", FileMode: 0o644},
-			)
-
-			env := make(map[string]string, len(containerRequest.ContainerRequest.Env)+3)
-			for k, v := range containerRequest.ContainerRequest.Env {
-				env[k] = v
-			}
-			env["TLS_CA_CERT"] = TLSCACertPath
-			env["TLS_CERT"] = TLSCertPath
-			env["TLS_KEY"] = TLSKeyPath
-			containerRequest.ContainerRequest.Env = env
+			pc.certPEM, pc.keyPEM = certPEM, keyPEM
 		}
+		containerRequest.ContainerRequest.Files = append(containerRequest.ContainerRequest.Files,
+			testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.certPEM), ContainerFilePath: TLSCACertPath, FileMode: 0o644},
+			testcontainers.ContainerFile{Reader: bytes.NewReader(pc.certPEM), ContainerFilePath: TLSCertPath, FileMode: 0o644},
+			testcontainers.ContainerFile{Reader: bytes.NewReader(pc.keyPEM), ContainerFilePath: TLSKeyPath, FileMode: 0o644},
+			// Place the CA in the OS trust store directory so
+			// update-ca-certificates can pick it up.
+			testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.certPEM), ContainerFilePath: "/usr/local/share/ca-certificates/testworld-ca.crt", FileMode: 0o644},
+			// Mount the pre-built combined CA bundle directly into
+			// the well-known trust store paths, avoiding per-container
+			// Docker API calls to read-modify-write the trust store.
+			testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.bundlePEM), ContainerFilePath: "/etc/ssl/certs/ca-certificates.crt", FileMode: 0o644},
+			testcontainers.ContainerFile{Reader: bytes.NewReader(w.tls.bundlePEM), ContainerFilePath: "/etc/pki/tls/certs/ca-bundle.crt", FileMode: 0o644},
+		)
+
+		env := make(map[string]string, len(containerRequest.ContainerRequest.Env)+3)
+		for k, v := range containerRequest.ContainerRequest.Env {
+			env[k] = v
+		}
+		env["TLS_CA_CERT"] = TLSCACertPath
+		env["TLS_CERT"] = TLSCertPath
+		env["TLS_KEY"] = TLSKeyPath
+		containerRequest.ContainerRequest.Env = env
+	}
+
+	if len(spec.EgressAllow) > 0 {
+		containerRequest.ContainerRequest.LifecycleHooks = append(containerRequest.ContainerRequest.LifecycleHooks,
+			w.egressHooks(pc, spec.EgressAllow))
+	}
 
-		if contextArchiveData != nil {
-			containerRequest.ContainerRequest.FromDockerfile.ContextArchive = bytes.NewReader(contextArchiveData)
+	if w.dns != nil {
+		containerRequest.ContainerRequest.Mounts = append(containerRequest.ContainerRequest.Mounts, w.dns.mount())
+		// Jobs are not registered with the resolver, as they may exit
+		// before their address can be read.
+		if !tmpl.job {
+			containerRequest.ContainerRequest.LifecycleHooks = append(containerRequest.ContainerRequest.LifecycleHooks,
+				w.dns.hooks(pc, tmpl))
 		}
+	}
 
-		// createFn performs the actual container creation. Event tracking lives
-		// here so the Gantt chart reflects actual creation time.
-		createFn := func() {
-			// Wait for dependencies to be ready before creating this container.
-			for _, dep := range spec.Requires {
-				for _, dpc := range dep.pending {
-					<-dpc.ready
-					if dpc.err != nil {
-						pc.err = fmt.Errorf("dependency %s failed: %w", dep.Name, dpc.err)
-						close(pc.ready)
-						return
-					}
-				}
-			}
+	if spec.LogTo != nil || spec.LogToTest {
+		containerRequest.ContainerRequest.LifecycleHooks = append(containerRequest.ContainerRequest.LifecycleHooks,
+			w.followHooks(pc, spec))
+	}
+
+	if w.opts.statsInterval > 0 {
+		containerRequest.ContainerRequest.LifecycleHooks = append(containerRequest.ContainerRequest.LifecycleHooks,
+			w.statsHooks(pc))
+	}
 
-			event := w.worldLog.newEvent("World: add %s container %s", kind, replicaName)
-			defer event.finish()
+	if spec.Capture {
+		if w.worldLog.dir != "" {
+			containerRequest.ContainerRequest.LifecycleHooks = append(containerRequest.ContainerRequest.LifecycleHooks,
+				w.captureHooks(pc))
+		} else {
+			w.t.Log("Capture requires a world log path, skipping for ", pc.name)
+		}
+	}
 
-			// Remove any stale container with the same name left over from a previous run.
-			//nolint:errcheck
-			w.docker.ContainerRemove(w.ctx, replicaName, client.ContainerRemoveOptions{Force: true})
+	if tmpl.contextArchiveData != nil {
+		containerRequest.ContainerRequest.FromDockerfile.ContextArchive = bytes.NewReader(tmpl.contextArchiveData)
+	}
 
-			container, err := testcontainers.GenericContainer(w.ctx, containerRequest)
+	// Added last, so helper containers started by the hooks above count
+	// towards starting the container rather than its wait strategy.
+	containerRequest.ContainerRequest.LifecycleHooks = append(containerRequest.ContainerRequest.LifecycleHooks,
+		phaseHooks(pc))
+	return containerRequest, nil
+}
 
-			// Write results before closing the channel (happens-before guarantee)
-			pc.container = container
-			pc.err = err
+// createReplica performs the actual container creation and closes pc.ready
+// when done. Event tracking lives here so the Gantt chart reflects actual
+// creation time.
+func (w *World) createReplica(tmpl *replicaTemplate, pc *pendingContainer, containerRequest testcontainers.GenericContainerRequest, step *Event) {
+	// Wait for dependencies to be ready before creating this container.
+	var depEvents []*Event
+	for _, dep := range tmpl.spec.Requires {
+		pc.mu.Lock()
+		pc.blockedOn = &dep
+		pc.mu.Unlock()
+		start := time.Now()
+		events, err := dep.awaitDependency()
+		depEvents = append(depEvents, events...)
+		w.recordWait(tmpl.name, dep.Name, "requires", time.Since(start))
+		if err != nil {
+			pc.err = fmt.Errorf("dependency %s failed: %w", dep.Name, err)
 			close(pc.ready)
+			return
 		}
+	}
+
+	pc.mu.Lock()
+	pc.blockedOn = nil
+	pc.mu.Unlock()
 
-		go createFn()
+	event := w.worldLog.newStepEvent(step, "create", "World: add %s container %s", tmpl.kind, pc.name)
+	defer event.finish()
+	event.dependsOn(depEvents...)
+	event.setReplica(pc)
+	pc.event = event
+
+	// Traced services join the world's trace below their creation span.
+	if tp := event.traceparent(); tp != "" {
+		if _, ok := containerRequest.ContainerRequest.Env["TRACEPARENT"]; !ok {
+			env := maps.Clone(containerRequest.ContainerRequest.Env)
+			if env == nil {
+				env = make(map[string]string)
+			}
+			env["TRACEPARENT"] = tp
+			containerRequest.ContainerRequest.Env = env
+		}
 	}
 
-	return wc
+	// Until the phase hooks take over, the time is spent resolving the image.
+	if !containerRequest.ShouldBuildImage() {
+		pc.phase = event.child("pull", "%s: pull image", pc.name)
+	}
+	defer func() { pc.phase.finish() }()
+
+	// Remove any stale container with the same name left over from a previous run.
+	//nolint:errcheck
+	w.docker.ContainerRemove(w.ctx, pc.name, client.ContainerRemoveOptions{Force: true})
+
+	container, err := testcontainers.GenericContainer(w.ctx, containerRequest)
+	if err == nil && tmpl.job {
+		// Jobs are ready once they have run to completion.
+		err = w.runJob(pc, container, tmpl.spec.ExitCode)
+	}
+	if err != nil && w.dns != nil {
+		// The records were published when the container started; a
+		// replica that failed to become ready must not be resolvable.
+		w.dns.unregister(pc)
+	}
+
+	// Write results before closing the channel (happens-before guarantee)
+	pc.container = container
+	pc.err = err
+	close(pc.ready)
+}
+
+// pinAddress validates a ContainerSpec.IPv4Address and reserves it on the
+// internal network so no other container can claim it.
+func (w *World) pinAddress(address, name string, replicas int) error {
+	addr, err := netip.ParseAddr(address)
+	if err != nil || !addr.Is4() {
+		return fmt.Errorf("%q is not an IPv4 address", address)
+	}
+	subnet := w.netCfg.internal
+	if !subnet.IsValid() {
+		return fmt.Errorf("pinning %s requires the world to be created with WithInternalSubnet", addr)
+	}
+	if replicas > 1 {
+		return fmt.Errorf("cannot pin %s on a group of %d replicas", addr, replicas)
+	}
+	if !subnet.Contains(addr) {
+		return fmt.Errorf("%s is outside the internal subnet %s", addr, subnet)
+	}
+	base := subnet.Addr().As4()
+	hostBits := uint32(1)<<(32-subnet.Bits()) - 1
+	var last [4]byte
+	binary.BigEndian.PutUint32(last[:], binary.BigEndian.Uint32(base[:])|hostBits)
+	broadcast := netip.AddrFrom4(last)
+	switch addr {
+	case subnet.Addr():
+		return fmt.Errorf("%s is the network address of %s", addr, subnet)
+	case subnet.Addr().Next():
+		return fmt.Errorf("%s is reserved for the gateway of %s", addr, subnet)
+	case broadcast:
+		return fmt.Errorf("%s is the broadcast address of %s", addr, subnet)
+	}
+	if err := shared.reserve(w.netCfg, addr, name); err != nil {
+		return err
+	}
+	w.pinned = append(w.pinned, addr)
+	return nil
 }
 
 // Await blocks until all replica containers are created and started.
 func (wc *WorldContainer) Await() {
-	if wc.isReady {
+	gen, ready := wc.group.readiness()
+	if ready {
 		return
 	}
-	event := wc.world.worldLog.newEvent("%s: await", wc.Name)
+	event := wc.world.worldLog.newStepEvent(wc.world.worldLog.currentStep(), "await", "%s: await", wc.Name)
 	defer event.finish()
-	wc.forEachReady(func(_ *pendingContainer) bool { return true })
-	wc.isReady = true
+	event.setContainer(wc.Name)
+	done := make(chan struct{})
+	defer close(done)
+	go wc.world.watchAwait(wc, done)
+	wc.forEachReady(func(pc *pendingContainer) bool {
+		event.dependsOn(pc.event)
+		return true
+	})
+	wc.group.markReady(gen)
 }
 
 // forEachReady runs fn concurrently for each replica, waiting for its creation
@@ -527,17 +894,17 @@ func (wc *WorldContainer) Await() {
 func (wc *WorldContainer) forEachReady(fn func(pc *pendingContainer) bool) {
 	// Wait for After dependencies before proceeding.
 	for _, dep := range wc.after {
-		for _, dpc := range dep.pending {
-			<-dpc.ready
-			if dpc.err != nil {
-				wc.world.t.Fatalf("After dependency %s failed: %v", dep.Name, dpc.err)
-			}
+		start := time.Now()
+		_, err := dep.awaitDependency()
+		wc.world.recordWait(wc.Name, dep.Name, "after", time.Since(start))
+		if err != nil {
+			wc.world.t.Fatalf("After dependency %s failed: %v", dep.Name, err)
 		}
 	}
 
 	var wg sync.WaitGroup
 	var failed atomic.Bool
-	for _, pc := range wc.pending {
+	for _, pc := range wc.replicas() {
 		wg.Add(1)
 		go func(pc *pendingContainer) {
 			defer wg.Done()
@@ -547,6 +914,12 @@ func (wc *WorldContainer) forEachReady(fn func(pc *pendingContainer) bool) {
 				failed.Store(true)
 				return
 			}
+			// Hold the replica so Scale cannot remove it while fn runs.
+			pc.inUse.RLock()
+			defer pc.inUse.RUnlock()
+			if pc.removed {
+				return
+			}
 			if !fn(pc) {
 				failed.Store(true)
 			}
@@ -560,23 +933,29 @@ func (wc *WorldContainer) forEachReady(fn func(pc *pendingContainer) bool) {
 
 // Exec executes a command in all replica containers concurrently.
 func (wc *WorldContainer) Exec(cmd []string, expectCode int) {
+	step := wc.world.worldLog.currentStep()
 	wc.forEachReady(func(pc *pendingContainer) bool {
-		event := wc.world.worldLog.newEvent("%s: exec %s", pc.name, strings.Join(cmd, " "))
+		event := wc.world.worldLog.newStepEvent(step, "exec", "%s: exec %s", pc.name, strings.Join(cmd, " "))
 		defer event.finish()
-		exitCode, logsReader, err := pc.container.Exec(wc.world.ctx, cmd, tcexec.Multiplexed())
+		event.dependsOn(pc.event)
+		event.setReplica(pc)
+		var output io.Writer = io.Discard
+		if event != nil {
+			output = event.log
+		}
+		exitCode, logsReader, err := pc.container.Exec(wc.world.ctx, cmd, taggedExecOutput(output, pc.name))
 		if err != nil {
+			event.fail("exec: %v", err)
 			wc.world.t.Errorf("Failed to exec in container %s: %v", pc.name, err)
 			return false
 		}
 		if logsReader != nil {
-			if event != nil {
-				io.Copy(event.log, logsReader)
-			} else {
-				io.Copy(io.Discard, logsReader)
-			}
+			io.Copy(io.Discard, logsReader)
 		}
+		event.setExitCode(exitCode)
 		if exitCode != expectCode {
-			wc.world.t.Errorf("Command %v exited with code %d (expected %d) in container %s", cmd, exitCode, expectCode, pc.name)
+			event.fail("exited with code %d (expected %d)", exitCode, expectCode)
+			wc.world.t.Errorf("Command [%s] exited with code %d (expected %d) in container %s", wc.world.secrets.redact(strings.Join(cmd, " ")), exitCode, expectCode, pc.name)
 			return false
 		}
 		return true
@@ -585,10 +964,14 @@ func (wc *WorldContainer) Exec(cmd []string, expectCode int) {
 
 // Wait waits for all replica containers concurrently with a given wait strategy.
 func (wc *WorldContainer) Wait(waitStrategy wait.Strategy) {
+	step := wc.world.worldLog.currentStep()
 	wc.forEachReady(func(pc *pendingContainer) bool {
-		event := wc.world.worldLog.newEvent("%s: wait", pc.name)
+		event := wc.world.worldLog.newStepEvent(step, "wait", "%s: wait", pc.name)
 		defer event.finish()
+		event.dependsOn(pc.event)
+		event.setReplica(pc)
 		if err := waitStrategy.WaitUntilReady(wc.world.ctx, pc.container); err != nil {
+			event.fail("%v", err)
 			wc.world.t.Errorf("Wait failed for container %s: %v", pc.name, err)
 			return false
 		}
@@ -598,9 +981,11 @@ func (wc *WorldContainer) Wait(waitStrategy wait.Strategy) {
 
 // LogFile copies a file from all replica containers to the world log concurrently.
 func (wc *WorldContainer) LogFile(path string) {
+	step := wc.world.worldLog.currentStep()
 	wc.forEachReady(func(pc *pendingContainer) bool {
-		event := wc.world.worldLog.newEvent("%s: log file %s", pc.name, path)
+		event := wc.world.worldLog.newStepEvent(step, "", "%s: log file %s", pc.name, path)
 		defer event.finish()
+		event.setReplica(pc)
 		reader, err := pc.container.CopyFileFromContainer(wc.world.ctx, path)
 		if err != nil {
 			wc.world.t.Errorf("Failed to copy file %s from container %s: %v", path, pc.name, err)
@@ -2,14 +2,35 @@ package testworld
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/moby/moby/api/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
 	testcontainers "github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
 	"github.com/testcontainers/testcontainers-go/wait"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // TestWorldCreation tests that a World can be created and destroyed properly.
@@ -66,10 +87,10 @@ func TestNewContainer(t *testing.T) {
 
 	wc.Await()
 
-	if len(wc.pending) != 1 {
-		t.Errorf("Expected 1 container, got %d", len(wc.pending))
+	if len(wc.replicas()) != 1 {
+		t.Errorf("Expected 1 container, got %d", len(wc.replicas()))
 	}
-	if wc.pending[0].container == nil {
+	if wc.replicas()[0].container == nil {
 		t.Error("Expected container to be non-nil")
 	}
 
@@ -249,7 +270,7 @@ func TestDestroyAwaitsContainers(t *testing.T) {
 		OnDestroy: func(wc WorldContainer) {
 			onDestroyCalled = true
 			wc.Exec([]string{"echo", "onDestroy called"}, 0)
-			for _, pc := range wc.pending {
+			for _, pc := range wc.replicas() {
 				select {
 				case <-pc.ready:
 					// Channel already closed: creation finished before onDestroy.
@@ -404,6 +425,1290 @@ func TestAfter(t *testing.T) {
 	client.Exec([]string{"ping", "-c", "1", server.Name}, 0)
 }
 
+// TestRunJob tests that RunJob waits for a job to run to completion with the
+// expected exit code.
+func TestRunJob(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	job := w.RunJob(ContainerSpec{
+		Image:    "alpine:latest",
+		Cmd:      []string{"sh", "-c", "echo migrating; sleep 2; exit 3"},
+		ExitCode: 3,
+		Replicas: 2,
+	})
+	for _, pc := range job.replicas() {
+		state, err := pc.container.State(w.ctx)
+		if err != nil {
+			t.Fatalf("Failed to inspect job %s: %v", pc.name, err)
+		}
+		if state.Running {
+			t.Errorf("Job %s is still running", pc.name)
+		}
+	}
+}
+
+// TestStartJob tests that a started job runs to completion before
+// containers that require it are created.
+func TestStartJob(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	job := w.StartJob(ContainerSpec{
+		Image: "alpine:latest",
+		Cmd:   []string{"sh", "-c", "echo migrating; sleep 2"},
+	})
+
+	dependent := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		Requires:  []WorldContainer{job},
+	})
+	dependent.Await()
+
+	// The job has exited by the time the dependant exists.
+	for _, pc := range job.replicas() {
+		state, err := pc.container.State(w.ctx)
+		if err != nil {
+			t.Fatalf("Failed to inspect job %s: %v", pc.name, err)
+		}
+		if state.Running {
+			t.Errorf("Job %s is still running", pc.name)
+		}
+	}
+}
+
+// TestLogTo tests that replica output is streamed while the container runs,
+// including output written before the wait strategy succeeds.
+func TestLogTo(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	r, pw := io.Pipe()
+	c := w.NewContainer(ContainerSpec{
+		Image:      "alpine:latest",
+		Cmd:        []string{"sh", "-c", "echo starting; sleep 1; echo ready; sleep 60"},
+		Replicas:   2,
+		LogTo:      pw,
+		WaitingFor: wait.ForLog("ready"),
+	})
+
+	// Both replicas announce themselves before they are ready.
+	lines := make(chan string, 64)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			default:
+			}
+		}
+	}()
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case line := <-lines:
+			if strings.HasSuffix(line, "] starting") {
+				seen[line] = true
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatalf("Timed out waiting for streamed output, got %v", seen)
+		}
+	}
+	for _, pc := range c.replicas() {
+		if !seen["["+pc.name+"] starting"] {
+			t.Errorf("No output streamed for %s", pc.name)
+		}
+	}
+	c.Await()
+}
+
+// TestResourceStats tests that the resource usage of replicas is sampled
+// while they run and can be queried from the test.
+func TestResourceStats(t *testing.T) {
+	w := New(t, "./logs", WithResourceStats(time.Second))
+	defer w.Destroy()
+
+	c := w.NewContainer(ContainerSpec{
+		Image:    "alpine:latest",
+		Cmd:      []string{"sh", "-c", "head -c 16m /dev/zero | tail > /dev/null; sleep 60"},
+		Replicas: 2,
+	})
+	c.Await()
+	time.Sleep(3 * time.Second)
+
+	stats := c.ResourceStats()
+	if len(stats) != 2 {
+		t.Fatalf("Expected stats for 2 replicas, got %d", len(stats))
+	}
+	for _, s := range stats {
+		if len(s.Samples) == 0 || s.PeakMemoryBytes == 0 {
+			t.Errorf("Expected memory samples for %s, got %+v", s.Replica, s)
+		}
+	}
+}
+
+// TestLineWriter tests that output is split into complete lines.
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	lw := &lineWriter{emit: func(line []byte) { lines = append(lines, string(line)) }}
+	lw.Write([]byte("one\ntw"))
+	lw.Write([]byte("o\nthree"))
+	lw.flush()
+	if want := []string{"one\n", "two\n", "three\n"}; !slices.Equal(lines, want) {
+		t.Errorf("Expected %q, got %q", want, lines)
+	}
+}
+
+// TestDependencyConditions tests that dependants wait for the condition of
+// each dependency: a healthcheck, a log line and a completed container.
+func TestDependencyConditions(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	healthy := w.NewContainer(ContainerSpec{
+		Image: "alpine:latest",
+		Cmd:   []string{"sh", "-c", "sleep 2; touch /tmp/healthy; sleep 60"},
+		ConfigModifier: func(c *container.Config) {
+			c.Healthcheck = &container.HealthConfig{
+				Test:     []string{"CMD", "test", "-f", "/tmp/healthy"},
+				Interval: 500 * time.Millisecond,
+			}
+		},
+	})
+	logger := w.NewContainer(ContainerSpec{
+		Image: "alpine:latest",
+		Cmd:   []string{"sh", "-c", "sleep 1; echo 'listening on port 8080'; sleep 60"},
+	})
+	seeder := w.NewContainer(ContainerSpec{
+		Image: "alpine:latest",
+		Cmd:   []string{"sh", "-c", "sleep 1"},
+	})
+
+	dependent := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		Requires:  []WorldContainer{healthy.Healthy(), logger.LogMatches(`listening on port \d+`)},
+		After:     []WorldContainer{seeder.CompletedSuccessfully()},
+	})
+	dependent.Await()
+
+	healthy.Exec([]string{"test", "-f", "/tmp/healthy"}, 0)
+	state, err := seeder.replicas()[0].container.State(w.ctx)
+	if err != nil {
+		t.Fatalf("Failed to inspect seeder: %v", err)
+	}
+	if state.Running || state.ExitCode != 0 {
+		t.Errorf("Expected seeder to have completed, got %+v", state)
+	}
+}
+
+// stateTarget is a wait.StrategyTarget that reports a sequence of states.
+type stateTarget struct {
+	wait.StrategyTarget
+	states []*container.State
+}
+
+// State returns the next state, repeating the last one.
+func (st *stateTarget) State(context.Context) (*container.State, error) {
+	s := st.states[0]
+	if len(st.states) > 1 {
+		st.states = st.states[1:]
+	}
+	return s, nil
+}
+
+// TestCompletedStrategy tests that completedStrategy waits for the container
+// to exit and requires exit code 0.
+func TestCompletedStrategy(t *testing.T) {
+	running := &container.State{Status: "running", Running: true}
+	ok := &container.State{Status: "exited", ExitCode: 0}
+	failed := &container.State{Status: "exited", ExitCode: 2}
+
+	ctx := context.Background()
+	if err := (completedStrategy{}).WaitUntilReady(ctx, &stateTarget{states: []*container.State{running, running, ok}}); err != nil {
+		t.Errorf("Expected success, got %v", err)
+	}
+	if err := (completedStrategy{}).WaitUntilReady(ctx, &stateTarget{states: []*container.State{failed}}); err == nil {
+		t.Error("Expected an error for exit code 2")
+	}
+	timeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := (completedStrategy{}).WaitUntilReady(timeout, &stateTarget{states: []*container.State{running}}); err == nil {
+		t.Error("Expected an error while still running")
+	}
+}
+
+// countingStrategy is a wait.Strategy that counts its invocations.
+type countingStrategy struct{ calls int }
+
+// WaitUntilReady implements wait.Strategy.
+func (cs *countingStrategy) WaitUntilReady(context.Context, wait.StrategyTarget) error {
+	cs.calls++
+	return nil
+}
+
+// TestDependencyConditionOnce tests that a condition is checked once per
+// replica no matter how many dependants wait on it.
+func TestDependencyConditionOnce(t *testing.T) {
+	w := &World{ctx: context.Background(), worldLog: &WorldLog{}}
+	pc := &pendingContainer{name: "db", ready: make(chan struct{})}
+	close(pc.ready)
+	wc := WorldContainer{world: w, Name: "db", group: newReplicaGroup(nil, false)}
+	wc.group.add(pc)
+
+	cs := &countingStrategy{}
+	dep := wc.Until(cs)
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dep.awaitDependency(); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if cs.calls != 1 {
+		t.Errorf("Expected the condition to be checked once, got %d", cs.calls)
+	}
+	if wc.cond != nil {
+		t.Error("Until modified the original WorldContainer")
+	}
+}
+
+// blockingStrategy is a wait.Strategy that is never met.
+type blockingStrategy struct{}
+
+// WaitUntilReady implements wait.Strategy.
+func (blockingStrategy) WaitUntilReady(ctx context.Context, _ wait.StrategyTarget) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// TestConditionTimeout tests that a condition that is never met fails its
+// dependants once the condition timeout has passed.
+func TestConditionTimeout(t *testing.T) {
+	w := &World{ctx: context.Background(), worldLog: &WorldLog{}}
+	w.opts.conditionTimeout = 100 * time.Millisecond
+	pc := &pendingContainer{name: "db", ready: make(chan struct{})}
+	close(pc.ready)
+	wc := WorldContainer{world: w, Name: "db", group: newReplicaGroup(nil, false)}
+	wc.group.add(pc)
+
+	dep := wc.Until(blockingStrategy{})
+	_, err := dep.awaitDependency()
+	if want := "db did not meet condition testworld.blockingStrategy within 100ms"; err == nil || err.Error() != want {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+}
+
+// TestAddDependencies tests that dependencies on containers that were never
+// created or belong to another world are rejected.
+func TestAddDependencies(t *testing.T) {
+	w := &World{}
+	other := &World{}
+	db := WorldContainer{world: w, Name: "db", group: newReplicaGroup(nil, false)}
+	foreign := WorldContainer{world: other, Name: "foreign", group: newReplicaGroup(nil, false)}
+
+	if err := w.addDependencies("app", ContainerSpec{Requires: []WorldContainer{db}, After: []WorldContainer{db.Healthy()}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []depEdge{
+		{from: "app", to: "db", kind: "requires"},
+		{from: "app", to: "db", kind: "after", condition: "healthy"},
+	}
+	if !slices.Equal(w.deps, want) {
+		t.Errorf("Expected edges %v, got %v", want, w.deps)
+	}
+
+	if err := w.addDependencies("web", ContainerSpec{After: []WorldContainer{foreign}}); err == nil {
+		t.Error("Expected an error for a dependency from another world")
+	}
+	if err := w.addDependencies("web", ContainerSpec{Requires: []WorldContainer{{Name: "ghost"}}}); err == nil {
+		t.Error("Expected an error for a dependency that was never created")
+	}
+	if len(w.deps) != len(want) {
+		t.Errorf("Rejected dependencies were added to the graph: %v", w.deps)
+	}
+}
+
+// TestDependencyDOT tests that waits are recorded per edge and rendered in
+// the DOT output.
+func TestDependencyDOT(t *testing.T) {
+	w := &World{deps: []depEdge{
+		{from: "app", to: "db", kind: "requires", condition: "healthy"},
+		{from: "client", to: "app", kind: "after"},
+	}}
+	w.recordWait("app", "db", "requires", 2*time.Second)
+	w.recordWait("app", "db", "requires", time.Second)
+	w.recordWait("client", "app", "after", 1500*time.Millisecond)
+
+	got := dependencyDOT("TestWorld", []string{"app", "client", "db"}, w.dependencies())
+	want := `digraph "TestWorld" {
+  rankdir=LR;
+  node [shape=box];
+  "app";
+  "client";
+  "db";
+  "app" -> "db" [label="requires (healthy), waited 2.000s", style=solid];
+  "client" -> "app" [label="after, waited 1.500s", style=dashed];
+}
+`
+	if got != want {
+		t.Errorf("Unexpected DOT output:\n%s", got)
+	}
+}
+
+// TestCriticalPath tests that the critical path follows recorded
+// dependencies and falls back to the previous step of the test.
+func TestCriticalPath(t *testing.T) {
+	t0 := time.Now()
+	at := func(s float64) time.Time { return t0.Add(time.Duration(s * float64(time.Second))) }
+	ev := func(id int64, kind string, start, finish float64) *Event {
+		return &Event{id: id, kind: kind, description: fmt.Sprintf("event %d", id), startTime: at(start), finishTime: at(finish), el: &WorldLog{}}
+	}
+
+	create := ev(0, "", 0, 1)
+	db := ev(1, "create", 1, 6)
+	dbPull := ev(2, "pull", 1, 5)
+	dbPull.parent = db
+	cache := ev(3, "create", 1, 2)
+	app := ev(4, "create", 6, 8)
+	app.dependsOn(db, cache)
+	await := ev(5, "await", 1, 8.1)
+	await.dependsOn(app)
+	exec := ev(6, "exec", 8.1, 9)
+	destroy := ev(7, "destroy", 9, 10)
+	logs := ev(8, "", 9, 10)
+	events := []*Event{create, db, dbPull, cache, app, await, exec, destroy, logs}
+
+	var got []int64
+	for _, e := range criticalPath(events, at(10)) {
+		got = append(got, e.id)
+	}
+	if want := []int64{0, 1, 4, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("Expected critical path %v, got %v", want, got)
+	}
+
+	sinks := timeSinks(events, at(10))
+	if len(sinks) != 2 || sinks[0].kind != "pull" || sinks[0].total != 4*time.Second || sinks[1].kind != "exec" {
+		t.Errorf("Unexpected time sinks: %+v", sinks)
+	}
+}
+
+// TestSteps tests that operations started inside a step become its
+// children, that background events do not, that steps nest, and that ending
+// a step ends the steps nested in it.
+func TestSteps(t *testing.T) {
+	el := &WorldLog{world: &World{}, eventsDir: t.TempDir()}
+	w := &World{worldLog: el}
+
+	outer := w.Step("outer")
+	exec := el.newStepEvent(el.currentStep(), "exec", "exec")
+	inner := w.Step("inner")
+	wait := el.newStepEvent(el.currentStep(), "wait", "wait")
+	phase := wait.child("pull", "phase")
+	query := el.newEvent("DNS: A db answered")
+	outer.End()
+	after := el.newStepEvent(el.currentStep(), "exec", "after")
+
+	if exec.parent != outer.event || inner.event.parent != outer.event || wait.parent != inner.event {
+		t.Error("Events were not attached to the innermost open step")
+	}
+	if query.parent != nil {
+		t.Error("Background event was attached to the open step")
+	}
+	if after.parent != nil {
+		t.Error("Event after the step ended was attached to it")
+	}
+	if inner.event.finishTime.IsZero() {
+		t.Error("Ending the outer step did not end the inner step")
+	}
+	inner.End()
+
+	want := []*Event{outer.event, exec, inner.event, wait, phase, query, after}
+	if got := eventTree(el.events); !slices.Equal(got, want) {
+		t.Errorf("Unexpected event order: %v", got)
+	}
+	if phase.depth() != 3 {
+		t.Errorf("Expected depth 3, got %d", phase.depth())
+	}
+	if !isRoot(exec) || !isRoot(wait) || isRoot(phase) || isRoot(outer.event) {
+		t.Error("Events inside steps should count as top-level, steps and phases not")
+	}
+}
+
+// newTestWorldLog returns a world log in a temporary directory for a world
+// without Docker, holding a single container "db" with one replica.
+func newTestWorldLog(t *testing.T, name string) (*WorldLog, *pendingContainer) {
+	w := &World{name: name, t: t, containers: make(map[string]WorldContainer)}
+	wc := WorldContainer{world: w, Name: "db", group: newReplicaGroup(&replicaTemplate{image: "postgres:latest"}, false)}
+	pc := &pendingContainer{name: "db", group: "db", aliases: []string{"db"}, ready: make(chan struct{})}
+	wc.group.add(pc)
+	w.containers["db"] = wc
+	w.order = append(w.order, "db")
+
+	el, err := NewWorldLog(w, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.worldLog = el
+	return el, pc
+}
+
+// TestJSONWorldLog tests that the JSON world log lists the inventory and
+// every event with its attributes and the location of its output.
+func TestJSONWorldLog(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestJSON")
+	create := el.newTypedEvent("create", "World: add postgres container db")
+	create.setReplica(pc)
+	create.finish()
+	exec := el.newTypedEvent("exec", "db: exec true")
+	exec.setReplica(pc)
+	exec.dependsOn(create)
+	fmt.Fprintln(exec.log, "exec output")
+	exec.setExitCode(3)
+	exec.finish()
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(el.artifactPath("TestJSON.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonWorldLog
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Containers) != 1 || doc.Containers[0].Image != "postgres:latest" || doc.Containers[0].Replicas[0].Name != "db" {
+		t.Errorf("Unexpected inventory: %+v", doc.Containers)
+	}
+	if len(doc.Events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(doc.Events))
+	}
+	got := doc.Events[1]
+	if got.Kind != "exec" || got.Container != "db" || got.Replica != "db" || got.ExitCode == nil || *got.ExitCode != 3 {
+		t.Errorf("Unexpected event: %+v", got)
+	}
+	if !slices.Equal(got.Deps, []int64{0}) || got.Finish == nil || got.Output == nil {
+		t.Fatalf("Unexpected event relations: %+v", got)
+	}
+
+	combined, err := os.ReadFile(got.Output.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := string(combined[got.Output.Offset : got.Output.Offset+got.Output.Length])
+	if !strings.HasPrefix(output, "Event 001 start: db: exec true\nexec output\n") {
+		t.Errorf("Output does not point at the event log: %q", output)
+	}
+}
+
+// TestTrace tests that events are exported on one track per replica with
+// their logs as args.
+func TestTrace(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestTrace")
+	world := el.newEvent("World: Create")
+	world.finish()
+	create := el.newTypedEvent("create", "World: add postgres container db")
+	create.setReplica(pc)
+	pull := create.child("pull", "db: pull image")
+	fmt.Fprintln(pull.log, "pulling")
+	pull.finish()
+	create.finish()
+
+	tracks := map[int]string{}
+	events := map[string]traceEvent{}
+	for _, te := range el.traceEvents() {
+		switch te.Ph {
+		case "M":
+			if te.Name == "thread_name" {
+				tracks[te.Tid] = te.Args["name"].(string)
+			}
+		case "X":
+			events[te.Name] = te
+		}
+	}
+	if !maps.Equal(tracks, map[int]string{0: "World", 1: "db"}) {
+		t.Errorf("Unexpected tracks: %v", tracks)
+	}
+	got := events["db: pull image"]
+	if got.Tid != 1 || got.Cat != "pull" || !strings.Contains(got.Args["log"].(string), "pulling") {
+		t.Errorf("Unexpected trace event: %+v", got)
+	}
+	if c := events["World: add postgres container db"]; got.Ts < c.Ts || got.Ts+got.Dur > c.Ts+c.Dur {
+		t.Errorf("Phase %+v is not nested in its parent %+v", got, c)
+	}
+}
+
+// TestTraceOverlap tests that events overlapping another event of their
+// replica without nesting in it are drawn on an extra lane.
+func TestTraceOverlap(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestTraceOverlap")
+	at := func(sec int) time.Time { return el.startTime.Add(time.Duration(sec) * time.Second) }
+	for _, e := range []struct {
+		kind, description string
+		start, finish     int
+	}{
+		{"logs", "db: logs", 1, 3},
+		{"exec", "db: exec true", 2, 4},
+		{"", "db: egress denied 1.1.1.1:443", 5, 6},
+	} {
+		event := el.newTypedEvent(e.kind, "%s", e.description)
+		event.setReplica(pc)
+		event.finish()
+		event.startTime, event.finishTime = at(e.start), at(e.finish)
+	}
+
+	tracks := map[int]string{}
+	tids := map[string]int{}
+	for _, te := range el.traceEvents() {
+		switch {
+		case te.Ph == "M" && te.Name == "thread_name":
+			tracks[te.Tid] = te.Args["name"].(string)
+		case te.Ph == "X":
+			tids[te.Name] = te.Tid
+		}
+	}
+	if !maps.Equal(tracks, map[int]string{0: "db", 1: "db (2)"}) {
+		t.Errorf("Unexpected tracks: %v", tracks)
+	}
+	if tids["db: logs"] != 0 || tids["db: exec true"] != 1 || tids["db: egress denied 1.1.1.1:443"] != 0 {
+		t.Errorf("Unexpected lanes: %v", tids)
+	}
+}
+
+// TestHTMLReport tests that the HTML report embeds the events and their logs
+// safely and does not load external assets.
+func TestHTMLReport(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestHTML")
+	el.world.opts.htmlReport = true
+	exec := el.newTypedEvent("exec", "db: exec true")
+	exec.setReplica(pc)
+	fmt.Fprintln(exec.log, "</script><b>output</b>")
+	exec.finish()
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(el.artifactPath("TestHTML.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	if !strings.Contains(html, "db: exec true") || !strings.Contains(html, "postgres:latest") {
+		t.Error("Report does not contain the events and the inventory")
+	}
+	if strings.Count(html, "</script>") != 1 || strings.Contains(html, "<b>output</b>") {
+		t.Error("Event log is not escaped")
+	}
+	for _, external := range []string{"src=", "href=", "http://", "https://"} {
+		if strings.Contains(html, external) {
+			t.Errorf("Report references external assets: %s", external)
+		}
+	}
+}
+
+// TestJUnit tests that Exec and Wait events become test cases grouped by
+// step, and that only failed cases carry logs.
+func TestJUnit(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestJUnit")
+	el.world.opts.junit = true
+	step := el.world.Step("migrate")
+	ok := el.newStepEvent(el.currentStep(), "exec", "db: exec true")
+	ok.setReplica(pc)
+	fmt.Fprintln(ok.log, "fine")
+	ok.finish()
+	failed := el.newStepEvent(el.currentStep(), "wait", "db: wait")
+	failed.setReplica(pc)
+	failed.fail("timeout")
+	failed.finish()
+	step.End()
+	logs := el.newTypedEvent("logs", "db: logs")
+	logs.setReplica(pc)
+	fmt.Fprintln(logs.log, "database log")
+	logs.finish()
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(el.artifactPath("TestJUnit.junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc junitTestSuites
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Suites) != 1 || doc.Suites[0].Tests != 2 || doc.Suites[0].Failures != 1 {
+		t.Fatalf("Unexpected suites: %+v", doc.Suites)
+	}
+	cases := doc.Suites[0].Cases
+	if cases[0].Classname != "TestJUnit/migrate" || cases[0].Failure != nil || cases[0].SystemOut != "" {
+		t.Errorf("Unexpected passing case: %+v", cases[0])
+	}
+	if cases[1].Failure == nil || cases[1].Failure.Message != "timeout" {
+		t.Errorf("Unexpected failing case: %+v", cases[1])
+	}
+	if !strings.Contains(cases[1].SystemOut, "Failed: timeout") || !strings.Contains(cases[1].SystemOut, "database log") {
+		t.Errorf("Failing case lacks logs: %q", cases[1].SystemOut)
+	}
+}
+
+// TestTracing tests that events are exported as OTLP spans below a root span
+// for the test, and that containers get the trace context of their creation.
+func TestTracing(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestTracing")
+	if err := el.startTracing(nil); err != nil {
+		t.Fatal(err)
+	}
+	create := el.newTypedEvent("create", "World: add postgres container db")
+	create.setReplica(pc)
+	traceparent := create.traceparent()
+	create.child("pull", "db: pull image").finish()
+	create.finish()
+	exec := el.newTypedEvent("exec", "db: exec false")
+	exec.fail("exited with code 1 (expected 0)")
+	exec.finish()
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(el.artifactPath("TestTracing.otlp.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Status       struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	spans := map[string]span{}
+	for line := range strings.Lines(string(data)) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}
+
+	root, ok := spans[t.Name()]
+	if !ok || root.ParentSpanID != "" {
+		t.Fatalf("Missing root span: %+v", spans)
+	}
+	created := spans["World: add postgres container db"]
+	if created.ParentSpanID != root.SpanID || created.TraceID != root.TraceID {
+		t.Errorf("Event span is not below the root span: %+v", created)
+	}
+	if spans["db: pull image"].ParentSpanID != created.SpanID {
+		t.Error("Phase span is not below its event span")
+	}
+	if spans["db: exec false"].Status.Code != 2 {
+		t.Error("Failed event span does not have error status")
+	}
+	if want := "00-" + created.TraceID + "-" + created.SpanID + "-01"; traceparent != want {
+		t.Errorf("Expected traceparent %s, got %s", want, traceparent)
+	}
+}
+
+// recordingExporter keeps exported spans across Shutdown, unlike
+// tracetest.InMemoryExporter.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(context.Context) error { return nil }
+
+// TestTracingWithoutLogPath tests that a world without a log path still
+// exports spans to explicit exporters.
+func TestTracingWithoutLogPath(t *testing.T) {
+	w := &World{name: "TestTracingNoPath", t: t}
+	el, err := NewWorldLog(w, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.worldLog = el
+	exporter := &recordingExporter{}
+	if err := el.startTracing([]sdktrace.SpanExporter{exporter}); err != nil {
+		t.Fatal(err)
+	}
+	if el.newEvent("World: Create").traceparent() == "" {
+		t.Error("Expected a trace context for containers")
+	}
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(exporter.spans); n != 2 {
+		t.Errorf("Expected the root span and one event span, got %d", n)
+	}
+
+	if err := (&WorldLog{world: w}).startTracing(nil); err == nil {
+		t.Error("Expected the file exporter to require a log path")
+	}
+}
+
+// TestLogRetention tests when the full world log is kept.
+func TestLogRetention(t *testing.T) {
+	for _, tc := range []struct {
+		policy LogRetention
+		failed bool
+		want   bool
+	}{
+		{LogAlways, false, true},
+		{LogAlways, true, true},
+		{LogOnFailure, false, false},
+		{LogOnFailure, true, true},
+		{LogNever, true, false},
+	} {
+		if got := tc.policy.keepFull(tc.failed); got != tc.want {
+			t.Errorf("keepFull(%v) of policy %d = %v, want %v", tc.failed, tc.policy, got, tc.want)
+		}
+	}
+}
+
+// TestLogSummary tests that a world log that is not kept holds only the
+// summary, or is removed with every other file the world wrote.
+func TestLogSummary(t *testing.T) {
+	for _, summary := range []bool{true, false} {
+		el, pc := newTestWorldLog(t, "TestSummary")
+		el.world.opts.logRetention, el.world.opts.logSummary = LogNever, summary
+		live := el.artifactPath(pc.name + ".live.log")
+		if err := os.WriteFile(live, []byte("output\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		el.track(live)
+		el.newTypedEvent("exec", "db: exec true").finish()
+		if err := el.finish(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(live); !os.IsNotExist(err) {
+			t.Error("Live log was not removed")
+		}
+		if _, err := os.Stat(el.artifactPath("TestSummary.json")); !os.IsNotExist(err) {
+			t.Error("JSON log was written")
+		}
+		data, err := os.ReadFile(el.combinedLogPath)
+		if !summary {
+			if !os.IsNotExist(err) {
+				t.Error("Combined log was not removed")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "Container Inventory:") || !strings.Contains(string(data), "Event Timeline") {
+			t.Errorf("Summary is missing the inventory or timeline:\n%s", data)
+		}
+		if strings.Contains(string(data), "Event Logs:") {
+			t.Errorf("Summary contains event logs:\n%s", data)
+		}
+	}
+}
+
+// TestFailureReports tests that output tails and failed operations are
+// reported per replica, also without a world log path.
+func TestFailureReports(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestFailure")
+	el, err := NewWorldLog(el.world, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &pendingContainer{name: "db-2", group: "db"}
+	exec := el.newTypedEvent("exec", "db: exec false")
+	exec.setReplica(pc)
+	fmt.Fprintln(exec.log, "no such table")
+	exec.fail("exited with code 1 (expected 0)")
+	exec.finish()
+	el.newTypedEvent("exec", "db-2: exec true").finish()
+	for _, p := range []*pendingContainer{pc, other} {
+		logs := el.newTypedEvent("logs", "%s: logs", p.name)
+		logs.setReplica(p)
+		for i := range 5 {
+			fmt.Fprintf(logs.log, "%s line %d\n", p.name, i)
+		}
+		logs.finish()
+	}
+
+	reports := el.failureReports(3)
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 reports, got %d: %q", len(reports), reports)
+	}
+	want := "=== db ===\n" +
+		"db: exec false: exited with code 1 (expected 0):\n" +
+		"    no such table\n" +
+		"    Failed: exited with code 1 (expected 0)\n" +
+		"output (last 3 of 5 lines):\n" +
+		"    db line 2\n    db line 3\n    db line 4\n"
+	if reports[0] != want {
+		t.Errorf("Expected report:\n%s\ngot:\n%s", want, reports[0])
+	}
+	if !strings.HasPrefix(reports[1], "=== db-2 ===\noutput (last 3 of 5 lines):") {
+		t.Errorf("Unexpected report: %q", reports[1])
+	}
+}
+
+// TestTaggedWriter tests that collected lines carry the Docker timestamp,
+// the replica name and the stream.
+func TestTaggedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	tw := taggedWriter(&buf, "db", "stderr", true)
+	fmt.Fprint(tw, "2024-05-01T10:00:00.5+02:00 first\nno timestamp\nlast")
+	tw.flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", lines)
+	}
+	if want := "2024-05-01T08:00:00.500000000Z [db stderr] first"; lines[0] != want {
+		t.Errorf("Expected %q, got %q", want, lines[0])
+	}
+	for _, line := range lines[1:] {
+		l, ok := parseOutputLine(line)
+		if !ok || time.Since(l.at) > time.Minute || !strings.Contains(line, " [db stderr] ") {
+			t.Errorf("Expected a line stamped now, got %q", line)
+		}
+	}
+	if !strings.HasSuffix(lines[2], "] last") {
+		t.Errorf("Expected the unterminated line to be flushed, got %q", lines[2])
+	}
+}
+
+// TestTaggedExecOutput tests that exec output is demultiplexed into tagged
+// lines and the returned reader ends once it is copied.
+func TestTaggedExecOutput(t *testing.T) {
+	// Frames of the Docker stream format: stream, three zero bytes, size.
+	var stream bytes.Buffer
+	frame := func(fd stdcopy.StdType, data string) {
+		stream.Write([]byte{byte(fd), 0, 0, 0})
+		binary.Write(&stream, binary.BigEndian, uint32(len(data)))
+		stream.WriteString(data)
+	}
+	frame(stdcopy.Stdout, "out\n")
+	frame(stdcopy.Stderr, "err\n")
+
+	var buf bytes.Buffer
+	opts := &tcexec.ProcessOptions{}
+	taggedExecOutput(&buf, "db").Apply(opts)
+	if opts.Reader != nil {
+		t.Fatal("Expected no reader before the exec is attached")
+	}
+	opts.Reader = &stream
+	taggedExecOutput(&buf, "db").Apply(opts)
+	if _, err := io.Copy(io.Discard, opts.Reader); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, " [db stdout] out\n") || !strings.Contains(out, " [db stderr] err\n") {
+		t.Errorf("Expected tagged stdout and stderr lines, got %q", out)
+	}
+}
+
+// TestMergedOutput tests that the world log ends with the output of all
+// replicas sorted by time.
+func TestMergedOutput(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestMerged")
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	line := func(sec int, replica, text string) string {
+		return fmt.Sprintf("%s [%s stdout] %s\n", base.Add(time.Duration(sec)*time.Second).Format(logLineLayout), replica, text)
+	}
+	logs := el.newTypedEvent("logs", "db: logs")
+	logs.setReplica(pc)
+	fmt.Fprint(logs.log, line(1, "db", "a")+line(3, "db", "c"))
+	logs.finish()
+	exec := el.newTypedEvent("exec", "db-2: exec true")
+	fmt.Fprint(exec.log, line(2, "db-2", "b")+"Failed: not a line\n")
+	exec.finish()
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(el.combinedLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, merged, ok := strings.Cut(string(data), "Merged Container Output:\n")
+	if !ok {
+		t.Fatalf("Expected a merged output section:\n%s", data)
+	}
+	if want := line(1, "db", "a") + line(2, "db-2", "b") + line(3, "db", "c"); merged != want {
+		t.Errorf("Expected merged output:\n%s\ngot:\n%s", want, merged)
+	}
+}
+
+// TestRedaction tests that secrets are masked in every file the world log
+// writes, including secrets split across writes.
+func TestRedaction(t *testing.T) {
+	el, pc := newTestWorldLog(t, "TestRedaction")
+	el.world.opts.htmlReport = true
+	el.world.opts.junit = true
+	el.world.secrets.add([]string{"hunter2"}, []*regexp.Regexp{regexp.MustCompile(`token=\w+`)})
+	if err := el.startTracing(nil); err != nil {
+		t.Fatal(err)
+	}
+	exec := el.newTypedEvent("exec", "db: exec login -p hunter2")
+	exec.setReplica(pc)
+	fmt.Fprint(exec.log, "password is hun")
+	fmt.Fprint(exec.log, "ter2\nurl?token=abc123\n")
+	exec.fail("login -p hunter2 exited with code 1")
+	exec.finish()
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := el.events[0].description; got != "db: exec login -p [REDACTED]" {
+		t.Errorf("Expected a masked description, got %q", got)
+	}
+	files, err := os.ReadDir(el.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(el.dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"hunter2", "abc123"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("Secret %q found in %s", secret, f.Name())
+			}
+		}
+	}
+	data, err := os.ReadFile(el.combinedLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "password is [REDACTED]\nurl?[REDACTED]\n") {
+		t.Errorf("Expected masked output in the world log:\n%s", data)
+	}
+}
+
+// TestStatsSample tests that Docker stats are converted like docker stats
+// does, and that the world log lists peaks and samples per replica.
+func TestStatsSample(t *testing.T) {
+	var resp container.StatsResponse
+	resp.Read = time.Now()
+	resp.CPUStats.CPUUsage.TotalUsage = 3_000
+	resp.CPUStats.SystemUsage = 20_000
+	resp.CPUStats.OnlineCPUs = 4
+	resp.PreCPUStats.CPUUsage.TotalUsage = 1_000
+	resp.PreCPUStats.SystemUsage = 10_000
+	resp.MemoryStats.Usage = 5 << 20
+	resp.MemoryStats.Stats = map[string]uint64{"inactive_file": 1 << 20}
+	resp.Networks = map[string]container.NetworkStats{
+		"eth0": {RxBytes: 100, TxBytes: 10},
+		"eth1": {RxBytes: 200, TxBytes: 20},
+	}
+	resp.BlkioStats.IoServiceBytesRecursive = []container.BlkioStatEntry{
+		{Op: "read", Value: 7}, {Op: "Write", Value: 9}, {Op: "Total", Value: 16},
+	}
+	s := statsSample(&resp)
+	want := StatsSample{Time: resp.Read, CPUPercent: 80, MemoryBytes: 4 << 20,
+		NetRxBytes: 300, NetTxBytes: 30, BlockReadBytes: 7, BlockWriteBytes: 9}
+	if s != want {
+		t.Errorf("Expected %+v, got %+v", want, s)
+	}
+
+	el, pc := newTestWorldLog(t, "TestStats")
+	rs := &replicaStats{replica: pc.name}
+	rs.add(StatsSample{Time: el.startTime.Add(time.Second), CPUPercent: 12.5, MemoryBytes: 3 << 20})
+	rs.add(StatsSample{Time: el.startTime.Add(2 * time.Second), CPUPercent: 2, MemoryBytes: 5 << 20, NetRxBytes: 1536})
+	pc.stats = rs
+	el.world.stats = append(el.world.stats, rs)
+
+	wc := el.world.containers["db"]
+	stats := wc.ResourceStats()
+	if len(stats) != 1 || stats[0].PeakCPUPercent != 12.5 || stats[0].PeakMemoryBytes != 5<<20 {
+		t.Errorf("Unexpected peaks: %+v", stats)
+	}
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(el.combinedLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Resource Usage:",
+		"  db       2        12.5%     5.0MiB       1.5KiB  0B      0B          0B\n",
+		"    at   2.000s  cpu=2.0% mem=5.0MiB net_rx=1.5KiB net_tx=0B blk_read=0B blk_write=0B\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %q in the world log:\n%s", want, data)
+		}
+	}
+}
+
+// TestEventIDPadding tests that past 999 events, every event ID in the world
+// log is padded to the same width.
+func TestEventIDPadding(t *testing.T) {
+	el, _ := newTestWorldLog(t, "TestPadding")
+	el.world.opts.htmlReport = true
+	for i := range 1001 {
+		e := el.newEvent("event %d", i)
+		if i == 7 {
+			fmt.Fprint(e.log, "no trailing newline")
+		}
+		e.finish()
+	}
+	if err := el.finish(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(el.combinedLogPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, want := range []string{"\n0007 | ", "\n1000 | ", "Event 0007 start: event 7\nno trailing newline\nEvent 0007 finish: ", "Event 1000 start: event 1000\n"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("Expected %q in the world log", want)
+		}
+	}
+	if strings.Contains(log, "Event 007 ") {
+		t.Error("Found an event ID padded to three digits")
+	}
+	html, err := os.ReadFile(el.artifactPath("TestPadding.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), `"id_width":4`) {
+		t.Error("HTML report does not pad event IDs to the world log's width")
+	}
+}
+
+// TestDiffLines tests that the diff reproduces both inputs.
+func TestDiffLines(t *testing.T) {
+	check := func(a, b []string) int {
+		t.Helper()
+		var gotA, gotB []string
+		changes := 0
+		for _, e := range diffLines(a, b) {
+			if e.op != '+' {
+				gotA = append(gotA, e.line)
+			}
+			if e.op != '-' {
+				gotB = append(gotB, e.line)
+			}
+			if e.op != ' ' {
+				changes++
+			}
+		}
+		if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+			t.Fatalf("Diff does not reproduce the inputs: %q %q", gotA, gotB)
+		}
+		return changes
+	}
+	if changes := check(strings.Fields("a b c e f g"), strings.Fields("a c d e g h")); changes != 4 {
+		t.Errorf("Expected 4 changes, got %d", changes)
+	}
+
+	// Compare with the edit distance from the longest common subsequence.
+	rng := rand.New(rand.NewPCG(1, 2))
+	random := func() []string {
+		s := make([]string, rng.IntN(12))
+		for i := range s {
+			s[i] = string(rune('a' + rng.IntN(3)))
+		}
+		return s
+	}
+	for range 500 {
+		a, b := random(), random()
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		if changes, want := check(a, b), len(a)+len(b)-2*lcs[0][0]; changes != want {
+			t.Fatalf("Expected %d changes from %q to %q, got %d", want, a, b, changes)
+		}
+	}
+}
+
+// TestDiffLinesLarge tests that diverging logs are diffed in memory
+// proportional to their length.
+func TestDiffLinesLarge(t *testing.T) {
+	a := make([]string, 20000)
+	b := make([]string, 20000)
+	for i := range a {
+		a[i] = fmt.Sprintf("line %d", i)
+		b[i] = a[i]
+		if i%10 == 0 {
+			b[i] = fmt.Sprintf("changed %d", i)
+		}
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	edits := diffLines(a, b)
+	runtime.ReadMemStats(&after)
+
+	changes := 0
+	for _, e := range edits {
+		if e.op != ' ' {
+			changes++
+		}
+	}
+	if changes != 4000 {
+		t.Errorf("Expected 4000 changes, got %d", changes)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+		t.Errorf("Diff allocated %s", formatBytes(alloc))
+	}
+}
+
+// TestDiffLogs tests that logs of different runs that only differ in timing
+// and generated names compare equal.
+func TestDiffLogs(t *testing.T) {
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "log_TestA_events.log")
+	pathB := filepath.Join(dir, "log_TestB_events.log")
+	os.WriteFile(pathA, []byte("000 |[####] (0.672s) World: Create\n"+
+		"  testa-alpine-1  image=alpine:latest ip=10.0.0.2\n"+
+		"Event 001 finish: duration 0.100s\n"), 0644)
+	os.WriteFile(pathB, []byte("000 |    [##########] (1.500s) World: Create\n"+
+		"  testb-alpine-1  image=alpine:latest ip=10.0.0.7\n"+
+		"Event 001 finish: duration 12.345s\n"), 0644)
+
+	var out strings.Builder
+	differ, err := DiffLogs(&out, pathA, pathB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ {
+		t.Errorf("Expected no differences, got:\n%s", out.String())
+	}
+
+	os.WriteFile(pathB, []byte("000 |[#] (0.1s) World: Create\n"+
+		"  testb-nginx-1  image=nginx:latest ip=10.0.0.7\n"+
+		"Event 001 finish: duration 0.100s\n"), 0644)
+	out.Reset()
+	if differ, err = DiffLogs(&out, pathA, pathB); err != nil || !differ {
+		t.Fatalf("Expected differences, got %v, %v", differ, err)
+	}
+	want := "@@ -1,3 +1,3 @@\n" +
+		" 000 | World: Create\n" +
+		"-  <world>-alpine-1  image=alpine:latest ip=<ip>\n" +
+		"+  <world>-nginx-1  image=nginx:latest ip=<ip>\n" +
+		" Event 001 finish: duration <dur>\n"
+	if !strings.HasSuffix(out.String(), want) {
+		t.Errorf("Expected diff ending in:\n%s\ngot:\n%s", want, out.String())
+	}
+}
+
+// TestJUnitShared tests that worlds are added to a shared JUnit file, each
+// replacing an earlier suite of the same name.
+func TestJUnitShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	for _, name := range []string{"A", "B", "A"} {
+		el, _ := newTestWorldLog(t, name)
+		el.newTypedEvent("exec", "exec").finish()
+		el.finishTime = time.Now()
+		if err := el.writeJUnit(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc junitTestSuites
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range doc.Suites {
+		names = append(names, s.Name)
+	}
+	if !slices.Equal(names, []string{"B", "A"}) {
+		t.Errorf("Unexpected suites: %v", names)
+	}
+}
+
+// TestFreeIndex tests that new replicas get the lowest number no live
+// replica has.
+func TestFreeIndex(t *testing.T) {
+	g := newReplicaGroup(nil, true)
+	if got := g.freeIndex(); got != 1 {
+		t.Errorf("Expected 1 for an empty group, got %d", got)
+	}
+	g.add(&pendingContainer{index: 1})
+	g.add(&pendingContainer{index: 3})
+	if got := g.freeIndex(); got != 2 {
+		t.Errorf("Expected 2, got %d", got)
+	}
+}
+
+// TestBlockingChain tests that the blocking chain follows unfinished Requires
+// and After dependencies.
+func TestBlockingChain(t *testing.T) {
+	newGroup := func(name string, pcs ...*pendingContainer) WorldContainer {
+		wc := WorldContainer{Name: name, group: newReplicaGroup(nil, false)}
+		for _, pc := range pcs {
+			wc.group.add(pc)
+		}
+		return wc
+	}
+	done := make(chan struct{})
+	close(done)
+
+	db := newGroup("db", &pendingContainer{name: "db", ready: make(chan struct{})})
+	cache := newGroup("cache", &pendingContainer{name: "cache", ready: done})
+	app := newGroup("app",
+		&pendingContainer{name: "app-1", ready: done},
+		&pendingContainer{name: "app-2", ready: make(chan struct{}), blockedOn: &db},
+	)
+	app.after = []WorldContainer{cache}
+	client := newGroup("client", &pendingContainer{name: "client", ready: make(chan struct{})})
+	client.after = []WorldContainer{app}
+
+	got := client.blockingChain(make(map[*replicaGroup]bool))
+	want := []string{
+		"client is after app",
+		"  app-2 requires db",
+		"    db is being created",
+		"client is being created",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected chain:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
 // TestReplicaFileReaders verifies that each replica receives the complete
 // contents of every io.Reader-based ContainerFile. Without the buffering fix,
 // the first replica goroutine to start would exhaust the shared reader,
@@ -447,15 +1752,15 @@ func TestReplicas(t *testing.T) {
 	wc := w.NewContainer(spec)
 
 	// The WorldContainer should have 3 pending replicas
-	if len(wc.pending) != 3 {
-		t.Fatalf("Expected 3 pending replicas, got %d", len(wc.pending))
+	if len(wc.replicas()) != 3 {
+		t.Fatalf("Expected 3 pending replicas, got %d", len(wc.replicas()))
 	}
 
 	// Wait for all replicas to be created
 	wc.Await()
 
 	// Verify each replica has a unique name with the right suffix
-	for i, pc := range wc.pending {
+	for i, pc := range wc.replicas() {
 		expectedName := fmt.Sprintf("%s-%d", wc.Name, i+1)
 		if pc.name != expectedName {
 			t.Errorf("Replica %d: expected name %q, got %q", i+1, expectedName, pc.name)
@@ -468,6 +1773,100 @@ func TestReplicas(t *testing.T) {
 	}
 }
 
+// TestScale tests that Scale adds replicas with the next names and removes
+// the highest-numbered ones, also while Exec runs on the group.
+func TestScale(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	wc := w.NewContainer(ContainerSpec{
+		Image: "alpine:latest",
+		Cmd:   []string{"sleep", "60"},
+	})
+	wc.Await()
+
+	wc.Scale(3)
+	wc.Await()
+	names := []string{wc.Name, wc.Name + "-2", wc.Name + "-3"}
+	for i, pc := range wc.replicas() {
+		if pc.name != names[i] {
+			t.Errorf("Replica %d: expected name %q, got %q", i+1, names[i], pc.name)
+		}
+		if !slices.Contains(pc.aliases, wc.Name) || !slices.Contains(pc.aliases, pc.name) {
+			t.Errorf("Replica %s is missing the group or its own alias: %v", pc.name, pc.aliases)
+		}
+	}
+	// The first replica keeps the aliases it was created with.
+	if first := wc.replicas()[0]; slices.Contains(first.aliases, wc.Name+"-1") {
+		t.Errorf("Replica %s got an indexed alias: %v", first.name, first.aliases)
+	}
+	wc.Exec([]string{"true"}, 0)
+
+	// Scale down while a slow Exec is running on every replica.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wc.Exec([]string{"sleep", "2"}, 0)
+	}()
+	time.Sleep(500 * time.Millisecond)
+	wc.Scale(1)
+	wg.Wait()
+
+	if got := len(wc.replicas()); got != 1 {
+		t.Fatalf("Expected 1 replica after scaling down, got %d", got)
+	}
+	wc.Exec([]string{"true"}, 0)
+
+	// New replicas reuse the names of removed ones.
+	wc.Scale(2)
+	if got := wc.replicas()[1].name; got != wc.Name+"-2" {
+		t.Errorf("Expected new replica %s-2, got %s", wc.Name, got)
+	}
+	wc.Exec([]string{"true"}, 0)
+}
+
+// TestRollingUpdate tests that RollingUpdate replaces every replica with the
+// new image while keeping names, aliases and certificates.
+func TestRollingUpdate(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	wc := w.NewContainer(ContainerSpec{
+		Image:    "alpine:3.19",
+		Cmd:      []string{"sleep", "60"},
+		Replicas: 3,
+		Aliases:  []string{"app"},
+	})
+	wc.Exec([]string{"grep", "-q", "^3.19", "/etc/alpine-release"}, 0)
+	before := wc.replicas()
+
+	wc.RollingUpdate(ContainerSpec{
+		Image: "alpine:3.20",
+		Cmd:   []string{"sleep", "60"},
+	}, RollingOpts{
+		MaxUnavailable: 2,
+		WaitingFor:     wait.ForExec([]string{"true"}),
+	})
+
+	after := wc.replicas()
+	if len(after) != len(before) {
+		t.Fatalf("Expected %d replicas after the update, got %d", len(before), len(after))
+	}
+	for i, pc := range after {
+		if pc == before[i] {
+			t.Errorf("Replica %s was not replaced", pc.name)
+		}
+		if pc.name != before[i].name || !slices.Equal(pc.aliases, before[i].aliases) {
+			t.Errorf("Replica %d changed identity: %s %v -> %s %v", i+1, before[i].name, before[i].aliases, pc.name, pc.aliases)
+		}
+		if !bytes.Equal(pc.certPEM, before[i].certPEM) {
+			t.Errorf("Replica %s got a new TLS certificate", pc.name)
+		}
+	}
+	wc.Exec([]string{"grep", "-q", "^3.20", "/etc/alpine-release"}, 0)
+}
+
 // TestReplicaExec tests that Exec runs on all replicas.
 func TestReplicaExec(t *testing.T) {
 	w := New(t, "./logs")
@@ -517,7 +1916,7 @@ func TestReplicaDNS(t *testing.T) {
 	)}, 0)
 
 	// Each individual replica should also be reachable by its own name
-	for _, pc := range servers.pending {
+	for _, pc := range servers.replicas() {
 		client.Exec([]string{"ping", "-c", "1", pc.name}, 0)
 	}
 }
@@ -548,7 +1947,7 @@ func TestReplicaHTTP(t *testing.T) {
 	)}, 0)
 
 	// Verify each individual replica is serving HTTP
-	for _, pc := range servers.pending {
+	for _, pc := range servers.replicas() {
 		client.Exec([]string{"wget", "-q", "-O", "/dev/null", fmt.Sprintf("http://%s:80/", pc.name)}, 0)
 	}
 }
@@ -698,7 +2097,7 @@ func TestTLSReplicas(t *testing.T) {
 	})
 
 	// Each individual replica should be reachable via HTTPS by its own name.
-	for _, pc := range servers.pending {
+	for _, pc := range servers.replicas() {
 		client.Exec([]string{
 			"curl", "-sf",
 			fmt.Sprintf("https://%s:8443/", pc.name),
@@ -862,3 +2261,506 @@ func TestSubdomainTLS(t *testing.T) {
 		fmt.Sprintf("https://tenant2.%s:8443/", server.Name),
 	}, 0)
 }
+
+// TestSubnetOptions verifies that subnet options are parsed and validated
+// before any network is created.
+func TestSubnetOptions(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		wantErr  string
+		internal string
+	}{
+		{name: "default"},
+		{name: "internal", opts: []Option{WithInternalSubnet("10.231.0.7/24")}, internal: "10.231.0.0/24"},
+		{name: "invalid", opts: []Option{WithExternalSubnet("10.231.0.0")}, wantErr: "invalid external subnet"},
+		{name: "ipv6", opts: []Option{WithInternalSubnet("fd00::/64")}, wantErr: "only IPv4"},
+		{name: "too small", opts: []Option{WithInternalSubnet("10.231.0.0/30")}, wantErr: "/29 or larger"},
+		{
+			name:    "overlap",
+			opts:    []Option{WithExternalSubnet("10.231.0.0/16"), WithInternalSubnet("10.231.1.0/24")},
+			wantErr: "overlaps",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var o worldOptions
+			for _, opt := range tc.opts {
+				opt(&o)
+			}
+			cfg, err := o.networkConfig()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error: got %v, want it to contain %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.internal != "" && cfg.internal.String() != tc.internal {
+				t.Errorf("internal subnet: got %s, want %s", cfg.internal, tc.internal)
+			}
+		})
+	}
+}
+
+// TestStaticIPv4Address verifies that a container can be pinned to a fixed
+// address on a configured internal subnet, and that conflicting or invalid
+// addresses are rejected with a clear error.
+func TestStaticIPv4Address(t *testing.T) {
+	w := New(t, "./logs", WithInternalSubnet("10.231.8.0/24"))
+	defer w.Destroy()
+
+	legacy := w.NewContainer(ContainerSpec{
+		Image:       "alpine:latest",
+		KeepAlive:   true,
+		IPv4Address: "10.231.8.10",
+	})
+
+	client := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		After:     []WorldContainer{legacy},
+	})
+
+	legacy.Exec([]string{"sh", "-c", "ip -4 addr | grep -q 'inet 10.231.8.10/24'"}, 0)
+	client.Exec([]string{"ping", "-c", "1", "10.231.8.10"}, 0)
+
+	for address, want := range map[string]string{
+		"10.231.8.10": "already assigned to " + legacy.Name,
+		"10.231.9.10": "outside the internal subnet",
+		"10.231.8.1":  "reserved for the gateway",
+		"10.231.8.0":  "network address",
+		"not-an-ip":   "not an IPv4 address",
+	} {
+		err := w.pinAddress(address, "other", 1)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("pinAddress(%q): got %v, want error containing %q", address, err, want)
+		}
+	}
+	if err := w.pinAddress("10.231.8.11", "group", 2); err == nil {
+		t.Error("pinAddress with replicas: expected error")
+	}
+}
+
+// TestParseDeniedPacket verifies destination extraction from the egress
+// helper's tcpdump output.
+func TestParseDeniedPacket(t *testing.T) {
+	for line, want := range map[string]string{
+		"12:00:00.000000 IP 172.18.0.3.41234 > 93.184.216.34.443: tcp 0\n": "93.184.216.34:443",
+		"12:00:00.000000 IP 172.18.0.3.5353 > 8.8.4.4.123: UDP, length 48": "8.8.4.4:123",
+		"12:00:00.000000 IP 172.18.0.3 > 8.8.8.8: ICMP echo request":       "8.8.8.8",
+		"12:00:00.000000 IP6 fd00::3.41234 > 2001:db8::1.443: tcp 0":       "[2001:db8::1]:443",
+		"12:00:00.000000 IP6 fd00::3 > 2001:db8::1: ICMP6, echo request":   "2001:db8::1",
+		"listening on nflog:1, link-type NFLOG":                            "",
+	} {
+		got, ok := parseDeniedPacket(line)
+		if ok != (want != "") || got != want {
+			t.Errorf("parseDeniedPacket(%q) = %q, %v; want %q", line, got, ok, want)
+		}
+	}
+}
+
+// TestValidateEgressAllow verifies that only addresses, CIDRs and hostnames
+// are accepted, since entries are interpolated into a shell script.
+func TestValidateEgressAllow(t *testing.T) {
+	if err := validateEgressAllow([]string{"1.1.1.1", "10.0.0.0/8", "2001:db8::/32", "mirror.example.com"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range []string{"", "example.com; rm -rf /", "$(id)", "host name", "-j", "--flush", "a..b", "host-.example.com"} {
+		if err := validateEgressAllow([]string{bad}); err == nil {
+			t.Errorf("validateEgressAllow(%q): expected error", bad)
+		}
+	}
+}
+
+// TestEgressScript verifies that DNS is only allowed to the world resolver
+// and that IPv6 is filtered by the same allowlist.
+func TestEgressScript(t *testing.T) {
+	script := egressScript([]string{"10.0.0.0/8", "2001:db8::/32", "mirror.example.com"}, netip.MustParseAddr("172.18.0.2"))
+	for _, want := range []string{
+		"iptables -A OUTPUT -d 172.18.0.2 -p udp --dport 53 -j ACCEPT\n",
+		"iptables -A OUTPUT -d 10.0.0.0/8 -j ACCEPT\n",
+		"iptables -A OUTPUT -d mirror.example.com -j ACCEPT\n",
+		"ip6tables -A OUTPUT -d 2001:db8::/32 -j ACCEPT\n",
+		"ip6tables -A OUTPUT -d mirror.example.com -j ACCEPT 2>/dev/null || true\n",
+		"ip6tables -A OUTPUT -j REJECT\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %q in script:\n%s", want, script)
+		}
+	}
+	for _, unwanted := range []string{"iptables -A OUTPUT -p udp --dport 53", "iptables -A OUTPUT -d 2001:db8::/32", "ip6tables -A OUTPUT -d 10.0.0.0/8"} {
+		if strings.Contains(script, unwanted) {
+			t.Errorf("Unexpected %q in script:\n%s", unwanted, script)
+		}
+	}
+	if strings.Contains(egressScript(nil, netip.Addr{}), "--dport 53") {
+		t.Error("Expected no DNS rule without the world resolver")
+	}
+}
+
+// TestEgressAllow verifies that a container with an allowlist can reach the
+// listed hosts and the rest of the world, while other external destinations
+// are rejected and reported.
+func TestEgressAllow(t *testing.T) {
+	w := New(t, "./logs")
+	defer w.Destroy()
+
+	peer := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		Isolated:  true,
+	})
+
+	restricted := w.NewContainer(ContainerSpec{
+		Image:       "alpine:latest",
+		KeepAlive:   true,
+		EgressAllow: []string{"1.1.1.1"},
+		After:       []WorldContainer{peer},
+	})
+
+	// World traffic and allowlisted hosts are reachable.
+	restricted.Exec([]string{"ping", "-c", "1", peer.Name}, 0)
+	restricted.Exec([]string{"nc", "-z", "-w", "5", "1.1.1.1", "443"}, 0)
+
+	// Everything else is rejected.
+	restricted.Exec([]string{"nc", "-z", "-w", "5", "8.8.8.8", "443"}, 1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Contains(restricted.DeniedEgress(), "8.8.8.8:443") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 8.8.8.8:443 to be denied, got %v", restricted.DeniedEgress())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if slices.Contains(restricted.DeniedEgress(), "1.1.1.1:443") {
+		t.Errorf("allowlisted destination reported as denied: %v", restricted.DeniedEgress())
+	}
+}
+
+// TestCapture verifies that per-container and network captures are written as
+// pcap files next to the world log and referenced from the inventory.
+func TestCapture(t *testing.T) {
+	logDir := t.TempDir()
+	w := New(t, logDir)
+
+	server := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		Capture:   true,
+	})
+	client := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		After:     []WorldContainer{server},
+	})
+
+	w.Capture("icmp")
+	client.Exec([]string{"ping", "-c", "3", server.Name}, 0)
+	w.Destroy()
+
+	pcaps := []string{
+		w.worldLog.artifactPath(server.Name + ".pcap"),
+		w.worldLog.artifactPath(w.name + "_capture_1.pcap"),
+	}
+	combined, err := os.ReadFile(w.worldLog.combinedLogPath)
+	if err != nil {
+		t.Fatalf("Failed to read world log: %v", err)
+	}
+	for _, path := range pcaps {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("Expected capture %s: %v", path, err)
+			continue
+		}
+		// pcap files start with a magic number in either byte order.
+		if len(data) < 24 || (!bytes.HasPrefix(data, []byte{0xd4, 0xc3, 0xb2, 0xa1}) && !bytes.HasPrefix(data, []byte{0xa1, 0xb2, 0xc3, 0xd4})) {
+			t.Errorf("Capture %s is not a pcap file", path)
+		}
+		if !bytes.Contains(combined, []byte(path)) {
+			t.Errorf("Inventory does not reference capture %s", path)
+		}
+	}
+}
+
+// dnsQuery sends a single query for name to a world DNS resolver over the
+// given network ("udp" or "tcp") and returns the parsed response.
+func dnsQuery(t *testing.T, network, addr, name string, qtype dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name + "."),
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	req, err := q.Pack()
+	if err != nil {
+		t.Fatalf("pack query: %v", err)
+	}
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial resolver: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 65535)
+	var resp []byte
+	if network == "tcp" {
+		conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(req))))
+		conn.Write(req)
+		var size uint16
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			t.Fatalf("read response size: %v", err)
+		}
+		resp = buf[:size]
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+	} else {
+		conn.Write(req)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		resp = buf[:n]
+	}
+
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil {
+		t.Fatalf("unpack response: %v", err)
+	}
+	if m.ID != 42 {
+		t.Errorf("response ID: got %d, want 42", m.ID)
+	}
+	return m
+}
+
+// TestWorldDNSHandler exercises the world DNS resolver directly, without
+// Docker: world names are answered from registered records, other names are
+// refused or forwarded, and every query is recorded.
+func TestWorldDNSHandler(t *testing.T) {
+	w := &World{name: t.Name(), t: t, worldLog: &WorldLog{}}
+
+	// A fake upstream resolver that answers every query with NXDOMAIN.
+	upstream, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var m dnsmessage.Message
+			if m.Unpack(buf[:n]) != nil {
+				continue
+			}
+			m.Response, m.RCode = true, dnsmessage.RCodeNameError
+			resp, _ := m.Pack()
+			upstream.WriteTo(resp, addr)
+		}
+	}()
+
+	for _, policy := range []DNSPolicy{DNSRefuse, DNSForward} {
+		d, err := newWorldDNS(w, policy, netip.MustParseAddr("127.0.0.1"))
+		if err != nil {
+			t.Fatalf("newWorldDNS: %v", err)
+		}
+		d.mu.Lock()
+		d.upstream = upstream.LocalAddr().String()
+		for i, addr := range []string{"10.231.0.10", "10.231.0.11"} {
+			pc := &pendingContainer{name: fmt.Sprintf("db-%d", i+1), aliases: []string{fmt.Sprintf("db-%d", i+1), "db"}}
+			d.entries[pc] = &dnsEntry{pc: pc, group: "db", index: i + 1, addr: netip.MustParseAddr(addr)}
+		}
+		d.rebuild()
+		d.mu.Unlock()
+		addr := fmt.Sprintf("127.0.0.1:%d", d.udp.LocalAddr().(*net.UDPAddr).Port)
+
+		for _, network := range []string{"udp", "tcp"} {
+			m := dnsQuery(t, network, addr, "DB", dnsmessage.TypeA)
+			if m.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 2 {
+				t.Errorf("%s A db: got rcode %v with %d answers, want 2 answers", network, m.RCode, len(m.Answers))
+			}
+		}
+
+		m := dnsQuery(t, "udp", addr, "example.com", dnsmessage.TypeA)
+		want := map[DNSPolicy]dnsmessage.RCode{DNSRefuse: dnsmessage.RCodeRefused, DNSForward: dnsmessage.RCodeNameError}[policy]
+		if m.RCode != want {
+			t.Errorf("policy %d: A example.com: got rcode %v, want %v", policy, m.RCode, want)
+		}
+
+		d.mu.Lock()
+		queries := d.queries
+		d.mu.Unlock()
+		if len(queries) != 3 {
+			t.Fatalf("policy %d: expected 3 recorded queries, got %+v", policy, queries)
+		}
+		if queries[0].Name != "db" || queries[0].Type != "A" || queries[0].Result != DNSAnswered {
+			t.Errorf("policy %d: unexpected first query %+v", policy, queries[0])
+		}
+		wantResult := map[DNSPolicy]string{DNSRefuse: DNSRefused, DNSForward: DNSForwarded}[policy]
+		if queries[2].Name != "example.com" || queries[2].Result != wantResult {
+			t.Errorf("policy %d: unexpected last query %+v", policy, queries[2])
+		}
+		d.udp.Close()
+		d.tcp.Close()
+	}
+}
+
+// TestDNSResolver verifies that containers resolve world names through the
+// world DNS resolver and that every query is observable from the test.
+func TestDNSResolver(t *testing.T) {
+	w := New(t, "./logs", WithDNS(DNSRefuse))
+	defer w.Destroy()
+
+	server := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		Aliases:   []string{"db"},
+	})
+	client := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		Isolated:  true,
+		After:     []WorldContainer{server},
+	})
+
+	client.Exec([]string{"ping", "-c", "1", "db"}, 0)
+	client.Exec([]string{"nslookup", "example.com"}, 1)
+
+	var sawDB, sawExample bool
+	for _, q := range w.DNSQueries() {
+		switch {
+		case q.Name == "db" && q.Result == DNSAnswered:
+			sawDB = true
+		case q.Name == "example.com" && q.Result == DNSRefused:
+			sawExample = true
+		}
+	}
+	if !sawDB || !sawExample {
+		t.Errorf("expected answered db and refused example.com queries, got %+v", w.DNSQueries())
+	}
+}
+
+// TestServicePorts verifies that exposed ports are published under their
+// number and, if configured, their service name.
+func TestServicePorts(t *testing.T) {
+	ports := servicePorts(
+		[]string{"80", "8080/tcp", "5353/udp", "127.0.0.1:9000:9000/tcp", "bogus"},
+		map[string]string{"80": "http", "5353/udp": "mdns"},
+	)
+	var got []string
+	for _, sp := range ports {
+		got = append(got, fmt.Sprintf("%v/%s:%d", sp.names, sp.proto, sp.port))
+	}
+	want := []string{"[80 http]/tcp:80", "[8080]/tcp:8080", "[5353 mdns]/udp:5353", "[9000]/tcp:9000"}
+	if !slices.Equal(got, want) {
+		t.Errorf("servicePorts: got %v, want %v", got, want)
+	}
+}
+
+// TestWorldDNSRecords verifies that SRV and per-replica A records are built
+// in replica order, served over DNS, and rebuilt when a replica goes away.
+func TestWorldDNSRecords(t *testing.T) {
+	w := &World{name: t.Name(), t: t, worldLog: &WorldLog{}}
+	d, err := newWorldDNS(w, DNSRefuse, netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("newWorldDNS: %v", err)
+	}
+	defer d.udp.Close()
+	defer d.tcp.Close()
+	w.dns = d
+
+	ports := servicePorts([]string{"8080/tcp"}, map[string]string{"8080/tcp": "http"})
+	var replicas []*pendingContainer
+	d.mu.Lock()
+	// Register in reverse to verify that ordering does not depend on it.
+	for i := 3; i >= 1; i-- {
+		pc := &pendingContainer{name: fmt.Sprintf("web-%d", i), aliases: []string{fmt.Sprintf("web-%d", i), "web", "frontend"}}
+		d.entries[pc] = &dnsEntry{
+			pc:     pc,
+			group:  "web",
+			index:  i,
+			addr:   netip.AddrFrom4([4]byte{10, 231, 0, byte(10 + i)}),
+			shared: []string{"web", "frontend"},
+			ports:  ports,
+		}
+		replicas = append([]*pendingContainer{pc}, replicas...)
+	}
+	d.rebuild()
+	d.mu.Unlock()
+
+	if got := fmt.Sprint(w.LookupHost("web")); got != "[10.231.0.11 10.231.0.12 10.231.0.13]" {
+		t.Errorf("LookupHost(web) = %s", got)
+	}
+	if got := fmt.Sprint(w.LookupHost("web-2")); got != "[10.231.0.12]" {
+		t.Errorf("LookupHost(web-2) = %s", got)
+	}
+	for _, svc := range []string{"http", "8080"} {
+		srvs := w.LookupSRV(svc, "tcp", "frontend")
+		var targets []string
+		for _, srv := range srvs {
+			targets = append(targets, fmt.Sprintf("%s:%d", srv.Target, srv.Port))
+		}
+		if !slices.Equal(targets, []string{"web-1:8080", "web-2:8080", "web-3:8080"}) {
+			t.Errorf("LookupSRV(%s, tcp, frontend) = %v", svc, targets)
+		}
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", d.udp.LocalAddr().(*net.UDPAddr).Port)
+	m := dnsQuery(t, "udp", addr, "_http._tcp.web", dnsmessage.TypeSRV)
+	if m.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 3 {
+		t.Fatalf("SRV query: got rcode %v with %d answers, want 3", m.RCode, len(m.Answers))
+	}
+	if srv, ok := m.Answers[0].Body.(*dnsmessage.SRVResource); !ok || srv.Target.String() != "web-1." || srv.Port != 8080 {
+		t.Errorf("first SRV answer: got %v", m.Answers[0].Body)
+	}
+
+	// Removing a replica rebuilds the record set.
+	d.unregister(replicas[1])
+	if got := len(w.LookupSRV("http", "tcp", "web")); got != 2 {
+		t.Errorf("after unregister: expected 2 SRV records, got %d", got)
+	}
+	if got := w.LookupHost("web-2"); got != nil {
+		t.Errorf("after unregister: expected no A record for web-2, got %v", got)
+	}
+}
+
+// TestDNSServiceRecords verifies that a replica group publishes SRV records
+// that containers can resolve through the world DNS resolver.
+func TestDNSServiceRecords(t *testing.T) {
+	w := New(t, "./logs", WithDNS(DNSRefuse))
+	defer w.Destroy()
+
+	servers := w.NewContainer(ContainerSpec{
+		Image:        "caddy:latest",
+		Replicas:     3,
+		ExposedPorts: []string{"80/tcp"},
+		ServiceNames: map[string]string{"80/tcp": "http"},
+	})
+	client := w.NewContainer(ContainerSpec{
+		Image:     "alpine:latest",
+		KeepAlive: true,
+		After:     []WorldContainer{servers},
+	})
+
+	client.Exec([]string{"nslookup", "-type=SRV", "_http._tcp." + servers.Name}, 0)
+
+	srvs := w.LookupSRV("http", "tcp", servers.Name)
+	if len(srvs) != 3 {
+		t.Fatalf("expected 3 SRV records, got %d", len(srvs))
+	}
+	for i, srv := range srvs {
+		if want := servers.replicas()[i].name; srv.Target != want || srv.Port != 80 {
+			t.Errorf("SRV record %d: got %s:%d, want %s:80", i, srv.Target, srv.Port, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package testworld
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// traceEvent is an entry in the Chrome Trace Event Format, as read by
+// Perfetto and chrome://tracing. Times are in microseconds.
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   int64          `json:"ts"`
+	Dur  int64          `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// traceTrack returns the name of the track an event is drawn on: its
+// replica, else its container group, else the world itself.
+func traceTrack(e *Event) string {
+	switch {
+	case e.replica != "":
+		return e.replica
+	case e.container != "":
+		return e.container
+	default:
+		return "World"
+	}
+}
+
+// traceSpan is the time range of a trace event in microseconds.
+type traceSpan struct{ start, end int64 }
+
+// fits reports whether s can share a thread with spans. Trace viewers
+// require the events of a thread to be disjoint or nested.
+func (s traceSpan) fits(spans []traceSpan) bool {
+	for _, o := range spans {
+		disjoint := s.end <= o.start || o.end <= s.start
+		nested := (o.start <= s.start && s.end <= o.end) || (s.start <= o.start && o.end <= s.end)
+		if !disjoint && !nested {
+			return false
+		}
+	}
+	return true
+}
+
+// traceEvents converts the events into trace events, one track per replica
+// or container group, in the order tracks first appear. Events that overlap
+// another event of their track without nesting in it, such as an exec while
+// logs are collected, are moved to an extra lane below the track. Each
+// event's log is included in its args.
+func (el *WorldLog) traceEvents() []traceEvent {
+	el.rw.RLock()
+	defer el.rw.RUnlock()
+
+	type placement struct {
+		track string
+		lane  int
+		span  traceSpan
+	}
+	var tracks []string
+	lanes := map[string][][]traceSpan{}
+	events := eventTree(el.events)
+	placements := make([]placement, len(events))
+	for i, e := range events {
+		track := traceTrack(e)
+		if _, ok := lanes[track]; !ok {
+			tracks = append(tracks, track)
+			lanes[track] = nil
+		}
+		// Derive the duration from rounded offsets, so phases stay nested
+		// within their parent.
+		start := e.startTime.Sub(el.startTime).Microseconds()
+		end := eventFinish(e, el.finishTime).Sub(el.startTime).Microseconds()
+		span := traceSpan{start, start + max(end-start, 1)}
+		lane := slices.IndexFunc(lanes[track], span.fits)
+		if lane < 0 {
+			lane = len(lanes[track])
+			lanes[track] = append(lanes[track], nil)
+		}
+		lanes[track][lane] = append(lanes[track][lane], span)
+		placements[i] = placement{track, lane, span}
+	}
+
+	trace := []traceEvent{{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  1,
+		Args: map[string]any{"name": el.world.name},
+	}}
+	// Number the threads so each track's extra lanes follow it.
+	tids := map[string]int{}
+	next := 0
+	for _, track := range tracks {
+		tids[track] = next
+		next += len(lanes[track])
+		for lane := range lanes[track] {
+			tid := tids[track] + lane
+			name := track
+			if lane > 0 {
+				name = fmt.Sprintf("%s (%d)", track, lane+1)
+			}
+			trace = append(trace,
+				traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tid, Args: map[string]any{"name": name}},
+				traceEvent{Name: "thread_sort_index", Ph: "M", Pid: 1, Tid: tid, Args: map[string]any{"sort_index": tid}},
+			)
+		}
+	}
+
+	for i, e := range events {
+		args := map[string]any{"id": e.id}
+		if e.exitCode != nil {
+			args["exit_code"] = *e.exitCode
+		}
+		if len(e.deps) > 0 {
+			deps := make([]int64, len(e.deps))
+			for i, d := range e.deps {
+				deps[i] = d.id
+			}
+			args["deps"] = deps
+		}
+		if data, err := el.readEventLog(e); err == nil {
+			args["log"] = string(data)
+		}
+		p := placements[i]
+		trace = append(trace, traceEvent{
+			Name: e.description,
+			Cat:  e.kind,
+			Ph:   "X",
+			Ts:   p.span.start,
+			Dur:  p.span.end - p.span.start,
+			Pid:  1,
+			Tid:  tids[p.track] + p.lane,
+			Args: args,
+		})
+	}
+	return trace
+}
+
+// writeTrace writes the event timeline as a Chrome trace, which can be
+// opened in https://ui.perfetto.dev or chrome://tracing.
+func (el *WorldLog) writeTrace() error {
+	data, err := json.Marshal(map[string]any{
+		"traceEvents":     el.traceEvents(),
+		"displayTimeUnit": "ms",
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(el.artifactPath(el.world.name+".trace.json"), data, 0644)
+}
@@ -1,14 +1,21 @@
 package testworld
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type WorldLog struct {
@@ -16,19 +23,41 @@ type WorldLog struct {
 	world           *World
 	combinedLog     io.WriteCloser
 	combinedLogPath string
+	dir             string // directory of the combined log and other artifacts
 	eventsDir       string
 	events          []*Event
+	steps           []*Event // open steps, innermost last
 	startTime       time.Time
 	finishTime      time.Time
 	eventCounter    int64
+	tracing         *tracing // exports events as spans, see WithTracing
+	artifacts       []string // files removed if the full log is not kept
 }
 
 type Event struct {
 	id          int64
 	description string
+	kind        string // category for the time sink summary, e.g. "pull" or "exec"
 	startTime   time.Time
 	finishTime  time.Time
 	log         io.WriteCloser
+	el          *WorldLog
+
+	// parent is the event this one is a phase of, and deps are the events
+	// it had to wait for. deps is guarded by el.rw.
+	parent *Event
+	deps   []*Event
+
+	// The container group and replica the event concerns, if any, and the
+	// exit code of the command it ran.
+	container string
+	replica   string
+	exitCode  *int
+
+	// failure describes why the operation the event records failed.
+	failure string
+
+	span trace.Span // span of the event when the world is traced
 }
 
 func NewWorldLog(world *World, path string) (*WorldLog, error) {
@@ -36,31 +65,147 @@ func NewWorldLog(world *World, path string) (*WorldLog, error) {
 	el.world = world
 	el.startTime = time.Now()
 
-	// Ensure we have a logs directory.
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return nil, err
-	}
-	if err = os.MkdirAll(absPath, 0755); err != nil {
-		return nil, err
-	}
+	// Without a path, events are recorded for tracing but no log files are
+	// written.
+	if path != "" {
+		// Ensure we have a logs directory.
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(absPath, 0755); err != nil {
+			return nil, err
+		}
 
-	// Create the event log file.
-	el.combinedLogPath = filepath.Join(absPath, "log_"+el.world.name+"_events.log")
-	el.combinedLog, err = os.Create(el.combinedLogPath)
-	if err != nil {
-		return nil, err
+		// Create the event log file.
+		el.dir = absPath
+		el.combinedLogPath = el.artifactPath(el.world.name + "_events.log")
+		el.combinedLog, err = os.Create(el.combinedLogPath)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Create a directory for temporary event logs. Each event writes to its
 	// own log file, and the files are concatenated at the end of the test.
 	el.eventsDir = filepath.Join(world.t.TempDir(), "events")
-	if err = os.MkdirAll(el.eventsDir, 0755); err != nil {
+	if err := os.MkdirAll(el.eventsDir, 0755); err != nil {
 		return nil, err
 	}
 	return &el, nil
 }
 
+// artifactPath returns the path of a file written next to the combined log,
+// named "log_<name>".
+func (el *WorldLog) artifactPath(name string) string {
+	return filepath.Join(el.dir, "log_"+name)
+}
+
+// eventLogPath returns the temporary log file of the event with the given id.
+func (el *WorldLog) eventLogPath(id int64) string {
+	return filepath.Join(el.eventsDir, fmt.Sprintf("event_%03d.log", id))
+}
+
+// writeEventLog copies the log of event e to w between its start and finish
+// lines. The temporary log holds only the event's output, and the lines are
+// generated here with IDs padded to width, as the number of events is only
+// known once the world is finished.
+func (el *WorldLog) writeEventLog(w io.Writer, e *Event, width int) (int64, error) {
+	f, err := os.Open(el.eventLogPath(e.id))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := fmt.Fprintf(w, "Event %0*d start: %s\n", width, e.id, e.description)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	lw := &lastByteWriter{w: w}
+	copied, err := io.Copy(lw, f)
+	written += copied
+	if err != nil || e.finishTime.IsZero() {
+		return written, err
+	}
+	// Output without a trailing newline must not swallow the finish line.
+	if copied > 0 && lw.last != '\n' {
+		n, err = io.WriteString(w, "\n")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	n, err = fmt.Fprintf(w, "Event %0*d finish: duration %.3fs\n", width, e.id, e.finishTime.Sub(e.startTime).Seconds())
+	return written + int64(n), err
+}
+
+// readEventLog returns the log of event e as writeEventLog writes it.
+func (el *WorldLog) readEventLog(e *Event) ([]byte, error) {
+	var b bytes.Buffer
+	if _, err := el.writeEventLog(&b, e, el.idWidth()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// lastByteWriter remembers the last byte written through it.
+type lastByteWriter struct {
+	w    io.Writer
+	last byte
+}
+
+// Write implements io.Writer.
+func (lw *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := lw.w.Write(p)
+	if n > 0 {
+		lw.last = p[n-1]
+	}
+	return n, err
+}
+
+// idWidth returns the number of digits event IDs are padded to in the
+// summary: at least three, and enough for the highest ID so columns line up
+// however many events the world had.
+func (el *WorldLog) idWidth() int {
+	return max(3, len(strconv.FormatInt(el.eventCounter-1, 10)))
+}
+
+// eventTree returns events in depth-first order: each top-level event in
+// start order, followed by its children.
+func eventTree(events []*Event) []*Event {
+	children := make(map[*Event][]*Event)
+	var roots []*Event
+	for _, e := range events {
+		if e.parent == nil {
+			roots = append(roots, e)
+		} else {
+			children[e.parent] = append(children[e.parent], e)
+		}
+	}
+	var ordered []*Event
+	var visit func(e *Event)
+	visit = func(e *Event) {
+		ordered = append(ordered, e)
+		for _, c := range children[e] {
+			visit(c)
+		}
+	}
+	for _, r := range roots {
+		visit(r)
+	}
+	return ordered
+}
+
+// depth returns how many ancestors the event has.
+func (event *Event) depth() int {
+	d := 0
+	for p := event.parent; p != nil; p = p.parent {
+		d++
+	}
+	return d
+}
+
 // finish finalizes the world log by writing a Gantt chart and concatenating all
 // event logs into the main log file.
 func (el *WorldLog) finish() error {
@@ -68,28 +213,61 @@ func (el *WorldLog) finish() error {
 		return nil
 	}
 
-	defer el.combinedLog.Close()
+	if el.combinedLog != nil {
+		defer el.combinedLog.Close()
+	}
 	el.finishTime = time.Now()
 
+	// Spans and JUnit results are reported for every test, whatever is
+	// kept of the log.
+	if err := el.stopTracing(); err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	if el.dir == "" {
+		return nil
+	}
+	if el.world.opts.junit {
+		if err := el.writeJUnit(el.world.opts.junitPath); err != nil {
+			return fmt.Errorf("failed to write JUnit report: %w", err)
+		}
+	}
+	if !el.world.opts.logRetention.keepFull(el.world.t.Failed()) {
+		return el.finishSummary()
+	}
+
 	el.printInventory()
+	el.printResourceStats()
+	el.printDependencies()
 	el.printGantt()
+	el.printCriticalPath()
 
-	// Concatenate all the event logs into the main event log.
+	// Concatenate all the event logs into the main event log, each followed
+	// by the logs of its children so steps are grouped together.
 	fmt.Fprintln(el.combinedLog, "\n\nEvent Logs:")
-	eventLogs, err := os.ReadDir(el.eventsDir)
-	if err != nil {
-		return fmt.Errorf("failed to read event logs directory: %w", err)
-	}
-	for _, eventLogFile := range eventLogs {
-		f, err := os.Open(filepath.Join(el.eventsDir, eventLogFile.Name()))
+	outputs := make(map[*Event]eventOutput)
+	width := el.idWidth()
+	for _, e := range eventTree(el.events) {
+		offset, _ := el.combinedLog.(io.Seeker).Seek(0, io.SeekCurrent)
+		n, err := el.writeEventLog(el.combinedLog, e, width)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("failed to open event log file %s: %w", eventLogFile.Name(), err)
+			return fmt.Errorf("failed to copy event log file %s: %w", el.eventLogPath(e.id), err)
 		}
-		defer f.Close()
+		outputs[e] = eventOutput{Path: el.combinedLogPath, Offset: offset, Length: n}
+	}
+	el.printMergedOutput()
 
-		_, err = io.Copy(el.combinedLog, f)
-		if err != nil {
-			return fmt.Errorf("failed to copy event log file %s: %w", eventLogFile.Name(), err)
+	if err := el.writeJSON(outputs); err != nil {
+		return fmt.Errorf("failed to write JSON world log: %w", err)
+	}
+	if err := el.writeTrace(); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	if el.world.opts.htmlReport {
+		if err := el.writeHTMLReport(outputs); err != nil {
+			return fmt.Errorf("failed to write HTML report: %w", err)
 		}
 	}
 
@@ -105,17 +283,25 @@ func (el *WorldLog) printInventory() {
 	}
 
 	fmt.Fprintln(el.combinedLog, "Container Inventory:")
-	for _, wc := range el.world.containers {
+	for _, wc := range el.world.groups() {
 		isolated := ""
 		if wc.isolated {
 			isolated = " [isolated]"
 		}
-		fmt.Fprintf(el.combinedLog, "  %s  image=%s%s\n", wc.Name, wc.image, isolated)
-		for i, pc := range wc.pending {
+		if len(wc.egress) > 0 {
+			isolated = " [egress: " + strings.Join(wc.egress, ", ") + "]"
+		}
+		address := ""
+		if wc.address != "" {
+			address = " ip=" + wc.address
+		}
+		fmt.Fprintf(el.combinedLog, "  %s  image=%s%s%s\n", wc.Name, wc.group.template().image, address, isolated)
+		replicas := wc.replicas()
+		for i, pc := range replicas {
 			prefix := "  └─"
-			if len(wc.pending) > 1 {
+			if len(replicas) > 1 {
 				prefix = fmt.Sprintf("  ├─ [%d]", i+1)
-				if i == len(wc.pending)-1 {
+				if i == len(replicas)-1 {
 					prefix = fmt.Sprintf("  └─ [%d]", i+1)
 				}
 			}
@@ -123,6 +309,41 @@ func (el *WorldLog) printInventory() {
 				prefix, pc.name, strings.Join(pc.aliases, ", "))
 		}
 	}
+
+	el.world.mu.Lock()
+	captures := el.world.captures
+	el.world.mu.Unlock()
+	if len(captures) > 0 {
+		fmt.Fprintln(el.combinedLog, "Packet Captures:")
+		for _, c := range captures {
+			fmt.Fprintf(el.combinedLog, "  %s  %s\n", c.label, c.path)
+		}
+	}
+	fmt.Fprintln(el.combinedLog)
+}
+
+// printDependencies writes the Requires/After edges between container groups
+// to the world log, each with the time the dependant waited for it, and
+// writes the same graph as a Graphviz DOT file next to the log.
+func (el *WorldLog) printDependencies() {
+	edges := el.world.dependencies()
+	if len(edges) == 0 {
+		return
+	}
+
+	fmt.Fprintln(el.combinedLog, "Dependency Graph:")
+	for _, e := range edges {
+		fmt.Fprintf(el.combinedLog, "  %s -> %s  %s\n", e.from, e.to, e.label())
+	}
+
+	nodes := el.world.order
+	dotPath := el.artifactPath(el.world.name + "_deps.dot")
+	dot := dependencyDOT(el.world.name, nodes, edges)
+	if err := os.WriteFile(dotPath, []byte(dot), 0644); err != nil {
+		fmt.Fprintf(el.combinedLog, "  Failed to write %s: %v\n", dotPath, err)
+	} else {
+		fmt.Fprintf(el.combinedLog, "  Graphviz: %s\n", dotPath)
+	}
 	fmt.Fprintln(el.combinedLog)
 }
 
@@ -134,10 +355,11 @@ func (el *WorldLog) printGantt() {
 
 	totalDuration := el.finishTime.Sub(el.startTime).Seconds()
 	fmt.Fprintf(el.combinedLog, "Event Timeline (Total: %.3fs):\n", totalDuration)
-	fmt.Fprintln(el.combinedLog, "ID  | Process Visualization")
-	fmt.Fprintln(el.combinedLog, "----|"+strings.Repeat("-", timelineWidth))
+	width := el.idWidth()
+	fmt.Fprintf(el.combinedLog, "%-*s| Process Visualization\n", width+1, "ID")
+	fmt.Fprintln(el.combinedLog, strings.Repeat("-", width+1)+"|"+strings.Repeat("-", timelineWidth))
 
-	for _, e := range el.events {
+	for _, e := range eventTree(el.events) {
 		// Calculate offset and bar length.
 		// If the event was never finished (e.g., test failed mid-way),
 		// treat it as running until the world was destroyed.
@@ -154,39 +376,119 @@ func (el *WorldLog) printGantt() {
 		padding := strings.Repeat(" ", startPos)
 		bar := strings.Repeat("#", barLen)
 
-		fmt.Fprintf(el.combinedLog, "%03d |%s[%s] (%.3fs) %s\n",
-			e.id, padding, bar, duration, e.description)
+		// Children are listed below their parent, indented by depth.
+		fmt.Fprintf(el.combinedLog, "%0*d |%s[%s] (%.3fs) %s%s\n",
+			width, e.id, padding, bar, duration, strings.Repeat("  ", e.depth()), e.description)
 	}
 }
 
 // newEvent logs the start of an event to the world log and returns an Event.
 func (el *WorldLog) newEvent(fomat string, args ...any) *Event {
+	return el.startEvent(nil, "", fomat, args...)
+}
+
+// newTypedEvent is newEvent for an event counted in the time sink summary
+// under kind.
+func (el *WorldLog) newTypedEvent(kind, format string, args ...any) *Event {
+	return el.startEvent(nil, kind, format, args...)
+}
+
+// newStepEvent is newTypedEvent for an operation started while step was open,
+// which becomes the event's parent. step may be nil.
+func (el *WorldLog) newStepEvent(step *Event, kind, format string, args ...any) *Event {
+	return el.startEvent(step, kind, format, args...)
+}
+
+// child starts an event for a phase of this event.
+func (event *Event) child(kind, format string, args ...any) *Event {
+	if event == nil {
+		return nil
+	}
+	return event.el.startEvent(event, kind, format, args...)
+}
+
+// dependsOn records that the event had to wait for other events.
+func (event *Event) dependsOn(deps ...*Event) {
+	if event == nil {
+		return
+	}
+	event.el.rw.Lock()
+	defer event.el.rw.Unlock()
+	for _, d := range deps {
+		if d != nil && !slices.Contains(event.deps, d) {
+			event.deps = append(event.deps, d)
+		}
+	}
+}
+
+// setContainer attributes the event to a container group.
+func (event *Event) setContainer(group string) {
+	if event != nil {
+		event.container = group
+	}
+}
+
+// setReplica attributes the event to a replica and its group.
+func (event *Event) setReplica(pc *pendingContainer) {
+	if event != nil {
+		event.container = pc.group
+		event.replica = pc.name
+	}
+}
+
+// setExitCode records the exit code of the command the event ran.
+func (event *Event) setExitCode(code int) {
+	if event != nil {
+		event.exitCode = &code
+	}
+}
+
+// fail records that the operation the event records failed, and notes the
+// reason in the event log.
+func (event *Event) fail(format string, args ...any) {
+	if event == nil {
+		return
+	}
+	event.failure = event.el.world.secrets.redact(fmt.Sprintf(format, args...))
+	fmt.Fprintf(event.log, "Failed: %s\n", event.failure)
+}
+
+// startEvent creates an event, optionally as a child of parent.
+func (el *WorldLog) startEvent(parent *Event, kind, format string, args ...any) *Event {
 	if el == nil || el.world == nil {
 		return nil
 	}
 
 	now := time.Now()
 
-	// Create and store a new event
+	// Create and store a new event.
 	el.rw.Lock()
 	event := &Event{
 		id:        el.eventCounter,
+		kind:      kind,
 		startTime: now,
+		el:        el,
+		parent:    parent,
+	}
+	// Phases concern the same container as the event they are part of.
+	if parent != nil && parent.kind != "step" {
+		event.container = parent.container
+		event.replica = parent.replica
 	}
 	el.events = append(el.events, event)
 	el.eventCounter++
 	el.rw.Unlock()
 
-	event.description = fmt.Sprintf(fomat, args...)
+	event.description = el.world.secrets.redact(fmt.Sprintf(format, args...))
+	el.startSpan(event)
 
-	// Create a new temporary log file for the event.
-	var err error
-	event.log, err = os.Create(filepath.Join(el.eventsDir, fmt.Sprintf("event_%03d.log", event.id)))
+	// Create a new temporary log file for the event. Secrets are masked as
+	// the log is written, so every report built from it is masked too.
+	f, err := os.Create(el.eventLogPath(event.id))
 	if err != nil {
 		return nil
 	}
-
-	fmt.Fprintf(event.log, "Event %03d start: %s\n", event.id, event.description)
+	event.log = el.world.secrets.writer(f)
 	log.Printf("🌍 %s", event.description)
 
 	return event
@@ -198,9 +500,14 @@ func (event *Event) finish() {
 		return
 	}
 
+	// Ending a step also ends the steps nested in it, so an event may be
+	// finished twice.
+	if !event.finishTime.IsZero() {
+		return
+	}
+
 	event.finishTime = time.Now()
-	duration := event.finishTime.Sub(event.startTime).Seconds()
-	fmt.Fprintf(event.log, "Event %03d finish: duration %.3fs\n", event.id, duration)
+	event.endSpan(event.finishTime)
 
 	event.log.Close()
 }